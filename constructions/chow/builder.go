@@ -0,0 +1,80 @@
+package chow
+
+import (
+	"context"
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// Builder collects the settings for generating a construction, so they can be given one at a time instead of all to
+// GenerateEncryptionKeys or GenerateDecryptionKeys. The default is an encryption construction with random independent
+// external masks.
+type Builder struct {
+	ctx        context.Context
+	key, seed  []byte
+	opts       common.KeyGenerationOpts
+	options    []Option
+	decryption bool
+}
+
+// NewBuilder returns a Builder with the default settings.
+func NewBuilder() *Builder {
+	return &Builder{
+		ctx:  context.Background(),
+		opts: common.IndependentMasks{common.RandomMask, common.RandomMask},
+	}
+}
+
+// WithKey sets the AES key to white-box. It's required.
+func (b *Builder) WithKey(key []byte) *Builder {
+	b.key = append([]byte{}, key...)
+	return b
+}
+
+// WithSeed sets the seed that all of the construction's randomness is derived from. It's required.
+func (b *Builder) WithSeed(seed []byte) *Builder {
+	b.seed = append([]byte{}, seed...)
+	return b
+}
+
+// WithMasks sets what external masks to put on the construction, like the opts argument of GenerateEncryptionKeys.
+func (b *Builder) WithMasks(opts common.KeyGenerationOpts) *Builder {
+	b.opts = opts
+	return b
+}
+
+// WithOptions adds optional settings, like RejectDegenerateShuffles.
+func (b *Builder) WithOptions(options ...Option) *Builder {
+	b.options = append(b.options, options...)
+	return b
+}
+
+// WithContext sets a context that stops generation when it's done.
+func (b *Builder) WithContext(ctx context.Context) *Builder {
+	b.ctx = ctx
+	return b
+}
+
+// ForDecryption makes the builder generate a decryption construction instead of an encryption construction.
+func (b *Builder) ForDecryption() *Builder {
+	b.decryption = true
+	return b
+}
+
+// Build generates the construction, and returns it with its input and output masks. It returns an error if the key or
+// seed is missing, or if generation is stopped by the context.
+func (b *Builder) Build() (out Construction, inputMask, outputMask matrix.Matrix, err error) {
+	if len(b.key) != 16 {
+		return out, nil, nil, errors.New("Key must be 16 bytes!")
+	} else if len(b.seed) == 0 {
+		return out, nil, nil, errors.New("Seed is missing!")
+	}
+
+	if b.decryption {
+		return GenerateDecryptionKeysContext(b.ctx, b.key, b.seed, b.opts, b.options...)
+	}
+	return GenerateEncryptionKeysContext(b.ctx, b.key, b.seed, b.opts, b.options...)
+}
@@ -0,0 +1,51 @@
+package chow
+
+import (
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// Cascaded is a cascade of two encryption constructions, for double encryption under two keys. It's built with Cascade.
+type Cascaded struct {
+	A, B *Construction
+
+	boundary matrix.Matrix // Takes A's output to B's input, or nil if the boundary is compatible.
+}
+
+// Cascade returns the cascade of a and then b. aOutputMask is the output mask a was generated with and bInputMask is
+// the input mask b was generated with. Between the two constructions, a's output is decoded and re-encoded for b, so
+// the cascade computes bOutputMask·AES_b(AES_a(aInputMask·x)).
+//
+// If the boundary is compatible--b's input encoding cancels a's output encoding, as with ChainEncrypt--both masks
+// should be nil, and a's output is fed to b as-is, so the value between them never appears unencoded.
+func Cascade(a, b *Construction, aOutputMask, bInputMask matrix.Matrix) Cascaded {
+	if aOutputMask == nil && bInputMask == nil {
+		return Cascaded{A: a, B: b}
+	} else if aOutputMask == nil || bInputMask == nil {
+		panic("Both masks on the boundary must be given, or neither!")
+	}
+
+	aOutputInv, ok := aOutputMask.Invert()
+	if !ok {
+		panic("Output mask isn't invertible!")
+	}
+	bInputInv, ok := bInputMask.Invert()
+	if !ok {
+		panic("Input mask isn't invertible!")
+	}
+
+	return Cascaded{A: a, B: b, boundary: bInputInv.Compose(aOutputInv)}
+}
+
+// BlockSize returns the block size of AES.
+func (c Cascaded) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst with A and then B. Dst and src may point at the same memory.
+func (c Cascaded) Encrypt(dst, src []byte) {
+	c.A.Encrypt(dst, src)
+
+	if c.boundary != nil {
+		copy(dst, c.boundary.Mul(matrix.Row(dst[:16])))
+	}
+
+	c.B.Encrypt(dst, dst)
+}
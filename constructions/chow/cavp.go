@@ -0,0 +1,42 @@
+package chow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteCAVP writes known-answer tests for the construction to w in the format of NIST CAVP's ECBVarTxt128.rsp: the
+// plaintexts are the 128 blocks with the first 1 to 128 bits set, and each ciphertext is the construction's encryption
+// of its plaintext. The KEY lines are left out, since the key is hidden in the construction. The ciphertexts only agree
+// with AES if the construction doesn't have external encodings.
+func WriteCAVP(c *Construction, w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# CAVS-style ECBVarTxt128 known-answer tests\n# KEY omitted: it's embedded in the white-box\n\n")
+	fmt.Fprintf(out, "[ENCRYPT]\n")
+
+	plaintext, ciphertext := [16]byte{}, [16]byte{}
+	for count := 0; count < 128; count++ {
+		plaintext[count/8] |= 0x80 >> uint(count%8)
+		c.Encrypt(ciphertext[:], plaintext[:])
+
+		fmt.Fprintf(out, "\nCOUNT = %v\nPLAINTEXT = %x\nCIPHERTEXT = %x\n", count, plaintext, ciphertext)
+	}
+
+	return out.Flush()
+}
+
+// RunMCT runs the inner loop of AESAVS's Monte Carlo Test for ECB encryption on the construction: starting from seed as
+// the plaintext, it encrypts iterations times, feeding each ciphertext back in as the next plaintext, and returns the
+// last ciphertext. AESAVS uses 1000 iterations. The outer loop of the test re-keys between runs of the inner loop,
+// which a white-box can't do, so a full test needs one construction per key.
+//
+// The result only agrees with AES if the construction doesn't have external encodings.
+func RunMCT(c *Construction, seed [16]byte, iterations int) [16]byte {
+	block := seed
+	for i := 0; i < iterations; i++ {
+		c.Encrypt(block[:], block[:])
+	}
+
+	return block
+}
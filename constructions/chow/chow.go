@@ -8,6 +8,9 @@
 package chow
 
 import (
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/matrix"
 	"github.com/OpenWhiteBox/primitives/table"
 
 	"github.com/OpenWhiteBox/AES/constructions/common"
@@ -30,6 +33,14 @@ type Construction struct {
 // BlockSize returns the block size of AES. (Necessary to implement cipher.Block.)
 func (constr Construction) BlockSize() int { return 16 }
 
+// String returns a one-line summary of the construction's structure, for logging.
+func (constr *Construction) String() string {
+	tables := 2 * (len(constr.InputMask) + len(constr.InputXORTables)*len(constr.InputXORTables[0]))
+	tables += 2 * len(constr.TBoxTyiTable) * (len(constr.TBoxTyiTable[0]) + len(constr.HighXORTable[0])*len(constr.HighXORTable[0][0]))
+
+	return fmt.Sprintf("chow: AES-128, %v rounds, %v tables, %v bytes serialized", len(constr.TBoxTyiTable)+1, tables, serializedSize)
+}
+
 // Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
 func (constr Construction) Encrypt(dst, src []byte) {
 	constr.crypt(dst, src, constr.shiftRows)
@@ -40,33 +51,111 @@ func (constr Construction) Decrypt(dst, src []byte) {
 	constr.crypt(dst, src, constr.unShiftRows)
 }
 
+// EncryptMany encrypts each block in blocks and returns the ciphertexts in order. It's the same as calling Encrypt on
+// each block, without the cost of copying the construction for every call.
+func (constr *Construction) EncryptMany(blocks [][16]byte) [][16]byte {
+	out, shift := make([][16]byte, len(blocks)), constr.shiftRows
+
+	for i := range blocks {
+		constr.crypt(out[i][:], blocks[i][:], shift)
+	}
+
+	return out
+}
+
+// EncryptUpTo encrypts src but stops after lastRound rounds (0 to 10), returning the intermediate state as it's held in
+// the construction--that is, still under the internal encodings that the next round's tables decode. EncryptUpTo(src,
+// 10) is the same as Encrypt. It's for debugging where a construction diverges from AES.
+func (constr Construction) EncryptUpTo(src [16]byte, lastRound int) (dst [16]byte) {
+	if lastRound < 0 || lastRound > 10 {
+		panic("Round must be between 0 and 10!")
+	} else if lastRound == 10 {
+		constr.Encrypt(dst[:], src[:])
+		return
+	}
+
+	dst = constr.EncodeInput(src)
+	constr.rounds(dst[:], constr.shiftRows, lastRound)
+
+	return
+}
+
+// EncodeInput pushes plaintext through the construction's input stage--the InputMask slices and the XOR tables after
+// them--and returns the state that the rounds take, under the construction's internal encodings. Feeding the result to
+// the rest of the construction gives the same output as Encrypt (or Decrypt) on plaintext, so it marks the boundary
+// between the external mask and the rounds.
+func (constr Construction) EncodeInput(plaintext [16]byte) (out [16]byte) {
+	copy(out[:], plaintext[:])
+	constr.encodeInput(out[:])
+
+	return
+}
+
+// ChainEncrypt encrypts src with a and then feeds a's output, still encoded, directly into b. It is only meaningful when
+// b's input encoding inverts a's output encoding, which can be arranged with an ExternalEncodingProvider, so that the
+// value between the two constructions never appears unencoded.
+func ChainEncrypt(a, b *Construction, src [16]byte) (dst [16]byte) {
+	a.Encrypt(dst[:], src[:])
+	b.Encrypt(dst[:], dst[:])
+
+	return
+}
+
+// EncryptForStandardAES encrypts src with the encryption construction c and strips off its external masks, which are
+// the ones returned when it was generated, so the ciphertext can be decrypted by any AES implementation with the same
+// key. Since c computes outputMask·AES(inputMask·x), it's given inputMask^(-1)·src and its output is decoded with
+// outputMask^(-1).
+func EncryptForStandardAES(c *Construction, inputMask, outputMask matrix.Matrix, src [16]byte) (dst [16]byte) {
+	inputInv, _ := inputMask.Invert()
+	outputInv, _ := outputMask.Invert()
+
+	copy(dst[:], inputInv.Mul(matrix.Row(src[:])))
+	c.Encrypt(dst[:], dst[:])
+	copy(dst[:], outputInv.Mul(matrix.Row(dst[:])))
+
+	return
+}
+
 // crypt pushes the first block in src through the lookup tables (which may compute encryption or decryption) and writes
 // the result to dst. shift is the permutation to apply to the state matrix before each round.
-func (constr Construction) crypt(dst, src []byte, shift func([]byte)) {
+func (constr *Construction) crypt(dst, src []byte, shift func([]byte)) {
 	copy(dst, src[:constr.BlockSize()])
 
-	// Remove input encoding.
-	stretched := constr.expandBlock(constr.InputMask, dst)
-	constr.InputXORTables.SquashBlocks(stretched, dst)
+	constr.encodeInput(dst)
+	constr.core(dst, shift)
+}
+
+// encodeInput pushes the first block of block through the input stage, removing the input mask, in place.
+func (constr *Construction) encodeInput(block []byte) {
+	stretched := constr.expandBlock(constr.InputMask, block)
+	constr.InputXORTables.SquashBlocks(stretched, block)
+}
+
+// core pushes a state that's been through encodeInput through the rest of the construction, in place.
+func (constr *Construction) core(block []byte, shift func([]byte)) {
+	constr.rounds(block, shift, 9)
 
-	for round := 0; round < 9; round++ {
-		shift(dst)
+	shift(block)
+
+	// Apply the final T-Box transformation and add the output encoding.
+	stretched := constr.expandBlock(constr.TBoxOutputMask, block)
+	constr.OutputXORTables.SquashBlocks(stretched, block)
+}
+
+// rounds pushes the state in block through the first n of the nine rounds between the external mask stages.
+func (constr *Construction) rounds(block []byte, shift func([]byte), n int) {
+	for round := 0; round < n; round++ {
+		shift(block)
 
 		// Apply the T-Boxes and Tyi Tables to each column of the state matrix.
 		for pos := 0; pos < 16; pos += 4 {
-			stretched := constr.ExpandWord(constr.TBoxTyiTable[round][pos:pos+4], dst[pos:pos+4])
-			constr.SquashWords(constr.HighXORTable[round][2*pos:2*pos+8], stretched, dst[pos:pos+4])
+			stretched := constr.ExpandWord(constr.TBoxTyiTable[round][pos:pos+4], block[pos:pos+4])
+			constr.SquashWords(constr.HighXORTable[round][2*pos:2*pos+8], stretched, block[pos:pos+4])
 
-			stretched = constr.ExpandWord(constr.MBInverseTable[round][pos:pos+4], dst[pos:pos+4])
-			constr.SquashWords(constr.LowXORTable[round][2*pos:2*pos+8], stretched, dst[pos:pos+4])
+			stretched = constr.ExpandWord(constr.MBInverseTable[round][pos:pos+4], block[pos:pos+4])
+			constr.SquashWords(constr.LowXORTable[round][2*pos:2*pos+8], stretched, block[pos:pos+4])
 		}
 	}
-
-	shift(dst)
-
-	// Apply the final T-Box transformation and add the output encoding.
-	stretched = constr.expandBlock(constr.TBoxOutputMask, dst)
-	constr.OutputXORTables.SquashBlocks(stretched, dst)
 }
 
 // shiftRows permutes the bytes of the first block of block, according to AES' ShiftRows operation.
@@ -85,6 +174,21 @@ func (constr *Construction) unShiftRows(block []byte) {
 	})
 }
 
+// ShiftRowsMap returns, for each byte of the state matrix after the ShiftRows at the start of the given round of
+// encryption, the position of the byte it draws from before ShiftRows. Rounds are numbered from 0 to 9, where round 9
+// is the ShiftRows before the final T-Box transformation.
+func ShiftRowsMap(round int) (out [16]int) {
+	if round < 0 || round > 9 {
+		panic("Round must be between 0 and 9!")
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		out[pos] = common.UnShiftRows(pos)
+	}
+
+	return
+}
+
 // ExpandWord expands one word of the state matrix with the T-Boxes composed with Tyi Tables.
 func (constr *Construction) ExpandWord(tboxtyi []table.Word, word []byte) [4][4]byte {
 	return [4][4]byte{tboxtyi[0].Get(word[0]), tboxtyi[1].Get(word[1]), tboxtyi[2].Get(word[2]), tboxtyi[3].Get(word[3])}
@@ -105,6 +209,16 @@ func (constr *Construction) SquashWords(xorTable [][3]table.Nibble, words [4][4]
 	}
 }
 
+// ApplyInputMask applies a mask that's been sliced into one table per input byte, like InputMask, to a whole block by
+// XORing each position's contribution together.
+func ApplyInputMask(masks [16]table.Block, in [16]byte) (out [16]byte) {
+	for pos := 0; pos < 16; pos++ {
+		common.XORBlocksInPlace(&out, masks[pos].Get(in[pos]))
+	}
+
+	return
+}
+
 // ExpandBlock expands the entire state matrix into sixteen blocks.
 func (constr *Construction) expandBlock(mask [16]table.Block, block []byte) (out [16][16]byte) {
 	for i := 0; i < 16; i++ {
@@ -113,3 +227,42 @@ func (constr *Construction) expandBlock(mask [16]table.Block, block []byte) (out
 
 	return
 }
+
+// CheckFinalRound returns an error if the construction's last round looks like it has MixColumns, which AES' last round
+// doesn't. Without MixColumns, each byte of the last round's input only reaches one byte of the output; with it, each
+// reaches a whole column. So it varies each byte going into TBoxOutputMask and counts the output bytes that change.
+//
+// External encodings spread every byte across the block anyway, so it's only meaningful for constructions whose
+// output mask is the identity.
+func (constr *Construction) CheckFinalRound() error {
+	finalRound := func(state [16]byte) (out [16]byte) {
+		stretched := constr.expandBlock(constr.TBoxOutputMask, state[:])
+		constr.OutputXORTables.SquashBlocks(stretched, out[:])
+
+		return
+	}
+
+	base := finalRound([16]byte{})
+
+	for pos := 0; pos < 16; pos++ {
+		for x := 1; x < 256; x++ {
+			state := [16]byte{}
+			state[pos] = byte(x)
+			cand := finalRound(state)
+
+			reached := 0
+			for i := 0; i < 16; i++ {
+				if cand[i] != base[i] {
+					reached++
+				}
+			}
+
+			if reached > 1 {
+				return fmt.Errorf("Byte %v of the last round's input reaches %v bytes of the output, so the last round "+
+					"has MixColumns!", pos, reached)
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,12 +2,29 @@ package chow
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/matrix"
+	"github.com/OpenWhiteBox/primitives/random"
+	"github.com/OpenWhiteBox/primitives/table"
 
 	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/rijndael"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
 
 	test_vectors "github.com/OpenWhiteBox/AES/constructions/test"
 )
@@ -30,6 +47,107 @@ func TestShiftRows(t *testing.T) {
 	}
 }
 
+func TestShiftRowsMap(t *testing.T) {
+	real := [16]int{0, 5, 10, 15, 4, 9, 14, 3, 8, 13, 2, 7, 12, 1, 6, 11}
+
+	for round := 0; round < 10; round++ {
+		if cand := ShiftRowsMap(round); cand != real {
+			t.Fatalf("Round %v: real disagrees with result! %v != %v", round, real, cand)
+		}
+	}
+}
+
+func TestStateOrdering(t *testing.T) {
+	// Byte r+4c of a block is row r and column c of the state matrix, exactly like FIPS 197's input array, so no
+	// conversion is needed between the construction's ordering and AES'. ShiftRows rotates row r left by r.
+	for r := 0; r < 4; r++ {
+		for c := 0; c < 4; c++ {
+			if cand := ShiftRowsMap(0)[r+4*c]; cand != r+4*((c+r)%4) {
+				t.Fatalf("State byte %v draws from byte %v, not FIPS 197's %v!", r+4*c, cand, r+4*((c+r)%4))
+			}
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	if summary := constr.String(); !strings.Contains(summary, "10 rounds") || !strings.Contains(summary, "3008 tables") {
+		t.Fatalf("Summary is wrong! %v", summary)
+	}
+}
+
+func TestTBoxTyiTable(t *testing.T) {
+	tbox := common.TBox{Constr: saes.Construction{key}, KeyByte1: key[3]}
+	tyi := common.TyiTable(2)
+
+	tt := TBoxTyiTable{tbox, tyi}
+
+	for x := 0; x < 256; x++ {
+		if real, cand := tyi.Get(tbox.Get(byte(x))), tt.Get(byte(x)); real != cand {
+			t.Fatalf("Real disagrees with result on %x! %x != %x", x, real, cand)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	buf := &bytes.Buffer{}
+	if err := constr.WriteDOT(buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+
+	if !strings.HasPrefix(dot, "digraph round4 {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("Output isn't a digraph!\n%v", dot)
+	} else if strings.Count(dot, "{") != 1 || strings.Count(dot, "}") != 1 {
+		t.Fatalf("Output has unbalanced braces!")
+	}
+
+	// Every statement should be a node or an edge between declared nodes.
+	nodes := make(map[string]bool)
+	lines := strings.Split(strings.TrimSuffix(dot, "}\n"), "\n")[1:]
+	for _, line := range lines[:len(lines)-1] {
+		if !strings.HasPrefix(line, "\t\"") || !strings.HasSuffix(line, ";") {
+			t.Fatalf("Malformed statement: %v", line)
+		}
+
+		if parts := strings.Split(line, "\""); len(parts) == 3 {
+			nodes[parts[1]] = true
+		} else if len(parts) != 5 || parts[2] != " -> " || !nodes[parts[1]] || !nodes[parts[3]] {
+			t.Fatalf("Malformed edge: %v", line)
+		}
+	}
+
+	// 16 T-Box/Tyi Tables, 16 MB^(-1) Tables, and 2 sets of 32x3 XOR Tables.
+	if len(nodes) != 16+16+2*32*3 {
+		t.Fatalf("Wrong number of nodes! %v", len(nodes))
+	}
+
+	if err := constr.WriteDOT(buf, 9); err == nil {
+		t.Fatalf("WriteDOT accepted an out-of-range round!")
+	}
+}
+
+func TestFinalRoundTable(t *testing.T) {
+	constr := saes.Construction{key}
+	roundKeys := constr.StretchedKey()
+
+	for pos := 0; pos < 16; pos++ {
+		tbox := FinalRoundTable(key, pos)
+
+		for x := 0; x < 256; x++ {
+			// ShiftRows moves the byte in position common.UnShiftRows(pos) to pos before the last AddRoundKey.
+			real := constr.SubByte(byte(x)^roundKeys[9][common.UnShiftRows(pos)]) ^ roundKeys[10][pos]
+
+			if cand := tbox.Get(byte(x)); real != cand {
+				t.Fatalf("Position %v: real disagrees with result on %x! %x != %x", pos, x, real, cand)
+			}
+		}
+	}
+}
+
 func TestUnmaskedEncrypt(t *testing.T) {
 	cand, real := make([]byte, 16), make([]byte, 16)
 
@@ -46,6 +164,366 @@ func TestUnmaskedEncrypt(t *testing.T) {
 	}
 }
 
+func TestNonDegenerateShuffle(t *testing.T) {
+	rs := random.NewSource("Test", seed)
+	label := make([]byte, 16)
+
+	// Derive the identity for the original label, so it has to be re-derived at least once.
+	cand := nonDegenerateShuffle(label, func(label []byte) encoding.Nibble {
+		if label[15] == 0 {
+			return encoding.IdentityByte{}
+		}
+		return rs.Shuffle(label)
+	})
+
+	if isDegenerate(cand) {
+		t.Fatalf("Returned shuffle is degenerate!")
+	} else if label[15] != 0 {
+		t.Fatalf("Caller's label was modified!")
+	}
+}
+
+func TestRejectDegenerateShuffles(t *testing.T) {
+	cand, real := make([]byte, 16), make([]byte, 16)
+
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask), RejectDegenerateShuffles())
+	constr.Encrypt(cand, input)
+
+	c, _ := aes.NewCipher(key)
+	c.Encrypt(real, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+// identityEncodings is an ExternalEncodingProvider with no input or output encodings.
+type identityEncodings struct{}
+
+func (identityEncodings) Input() encoding.Block  { return encoding.IdentityBlock{} }
+func (identityEncodings) Output() encoding.Block { return encoding.IdentityBlock{} }
+
+func TestExternalEncodingProvider(t *testing.T) {
+	cand, real := make([]byte, 16), make([]byte, 16)
+
+	constr, _, _ := GenerateEncryptionKeys(key, seed, identityEncodings{})
+	constr.Encrypt(cand, input)
+
+	c, _ := aes.NewCipher(key)
+	c.Encrypt(real, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestChainEncrypt(t *testing.T) {
+	keyB := []byte{87, 104, 105, 116, 101, 32, 66, 111, 120, 32, 83, 116, 97, 103, 101, 50}
+
+	// A's output encoding is cancelled by B's input encoding.
+	mask := SeedEncodings{seed, common.MatchingMasks{}, false, ""}
+
+	a, _, _ := GenerateEncryptionKeys(key, seed, fixedEncodings{encoding.IdentityBlock{}, mask.Input()})
+	b, _, _ := GenerateEncryptionKeys(keyB, seed, fixedEncodings{mask.Output(), encoding.IdentityBlock{}})
+
+	in := [16]byte{}
+	copy(in[:], input)
+	cand := ChainEncrypt(&a, &b, in)
+
+	// Calculate the real output by decoding A's output and encrypting it under B's key.
+	real := [16]byte{}
+	cA, _ := aes.NewCipher(key)
+	cB, _ := aes.NewCipher(keyB)
+	cA.Encrypt(real[:], in[:])
+	cB.Encrypt(real[:], real[:])
+
+	if real != cand {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestCascade(t *testing.T) {
+	keyB := []byte{87, 104, 105, 116, 101, 32, 66, 111, 120, 32, 83, 116, 97, 103, 101, 50}
+
+	a, aInput, aOutput := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	b, bInput, bOutput := GenerateEncryptionKeys(keyB, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	// Calculate the real output by encrypting under A's key and then B's key.
+	real := [16]byte{}
+	cA, _ := aes.NewCipher(key)
+	cB, _ := aes.NewCipher(keyB)
+	cA.Encrypt(real[:], input)
+	cB.Encrypt(real[:], real[:])
+
+	// Strip the cascade's external masks, which are A's input mask and B's output mask.
+	aInputInv, _ := aInput.Invert()
+	bOutputInv, _ := bOutput.Invert()
+
+	cand := make([]byte, 16)
+	Cascade(&a, &b, aOutput, bInput).Encrypt(cand, aInputInv.Mul(matrix.Row(input)))
+
+	if cand = bOutputInv.Mul(matrix.Row(cand)); !bytes.Equal(real[:], cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// Without external masks, the boundary is compatible.
+	a, _, _ = GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	b, _, _ = GenerateEncryptionKeys(keyB, seed, common.SameMasks(common.IdentityMask))
+
+	Cascade(&a, &b, nil, nil).Encrypt(cand, input)
+	if !bytes.Equal(real[:], cand) {
+		t.Fatalf("Real disagrees with result on a compatible boundary! %x != %x", real, cand)
+	}
+}
+
+func TestEncryptForStandardAES(t *testing.T) {
+	constr, inputMask, outputMask := GenerateEncryptionKeys(
+		key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask},
+	)
+	c, _ := aes.NewCipher(key)
+
+	in := [16]byte{}
+	copy(in[:], input)
+
+	ciphertext := EncryptForStandardAES(&constr, inputMask, outputMask, in)
+
+	cand := make([]byte, 16)
+	c.Decrypt(cand, ciphertext[:])
+	if !bytes.Equal(input, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", input, cand)
+	}
+}
+
+func TestCompatibleBoundary(t *testing.T) {
+	mask := SeedEncodings{seed, common.MatchingMasks{}, false, ""}
+	other := SeedEncodings{seed, common.IndependentMasks{common.RandomMask, common.RandomMask}, false, ""}
+
+	a := fixedEncodings{encoding.IdentityBlock{}, mask.Input()}
+	b := fixedEncodings{mask.Output(), encoding.IdentityBlock{}}
+
+	if !CompatibleBoundary(a, b) {
+		t.Fatalf("Matched boundary wasn't compatible!")
+	} else if CompatibleBoundary(a, fixedEncodings{other.Output(), encoding.IdentityBlock{}}) {
+		t.Fatalf("Mismatched boundary was compatible!")
+	}
+}
+
+func TestSeedEncodings(t *testing.T) {
+	opts := common.IndependentMasks{common.RandomMask, common.RandomMask}
+
+	_, inputMask, outputMask := GenerateEncryptionKeys(key, seed, opts)
+	_, candInput, candOutput := GenerateEncryptionKeys(key, seed, SeedEncodings{seed, opts, false, ""})
+
+	if !inputMask.Equals(candInput) || !outputMask.Equals(candOutput) {
+		t.Fatalf("SeedEncodings didn't reproduce the seed-derived masks!")
+	}
+}
+
+func TestSeedStrength(t *testing.T) {
+	weak, err := SeedStrength(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strong, err := SeedStrength(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if weak >= strong {
+		t.Fatalf("Zero seed is at least as strong as random seed! %v >= %v", weak, strong)
+	} else if _, err := SeedStrength(nil); err == nil {
+		t.Fatalf("Empty seed has a strength!")
+	}
+
+	// Strength is a count of bits, less what the degenerate encodings give away.
+	if strong > 8*len(seed) || strong < 8*len(seed)-4 {
+		t.Fatalf("Random seed has implausible strength %v!", strong)
+	} else if short, _ := SeedStrength(seed[:8]); short >= MinSeedStrength {
+		t.Fatalf("8-byte seed is strong enough! %v >= %v", short, MinSeedStrength)
+	}
+
+	if _, _, _, err := GenerateEncryptionKeysStrict(key, make([]byte, 16), common.SameMasks(common.IdentityMask)); err == nil {
+		t.Fatalf("Zero seed was accepted!")
+	} else if _, _, _, err := GenerateEncryptionKeysStrict(key, seed[:8], common.SameMasks(common.IdentityMask)); err == nil {
+		t.Fatalf("Short seed was accepted!")
+	} else if _, _, _, err := GenerateEncryptionKeysStrict(key, seed, common.SameMasks(common.IdentityMask)); err != nil {
+		t.Fatalf("Random seed was rejected! %v", err)
+	}
+}
+
+// cancelAfter is a context that's cancelled after its Err method has been called a fixed number of times.
+type cancelAfter struct {
+	context.Context
+	calls int
+}
+
+func (ca *cancelAfter) Err() error {
+	if ca.calls == 0 {
+		return context.Canceled
+	}
+
+	ca.calls--
+	return nil
+}
+
+func TestGenerateKeysContext(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	// Cancel generation after a few rounds.
+	ctx := &cancelAfter{context.Background(), 3}
+
+	constr, inputMask, outputMask, err := GenerateEncryptionKeysContext(ctx, key, seed, common.SameMasks(common.IdentityMask))
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v!", err)
+	} else if constr.TBoxTyiTable[0][0] != nil || inputMask != nil || outputMask != nil {
+		t.Fatalf("Partial construction wasn't discarded!")
+	} else if runtime.NumGoroutine() > goroutines {
+		t.Fatalf("Generation leaked goroutines!")
+	}
+
+	// A context that isn't cancelled should give the same construction as GenerateEncryptionKeys.
+	cand, real := make([]byte, 16), make([]byte, 16)
+
+	constr, _, _, err = GenerateEncryptionKeysContext(context.Background(), key, seed, common.SameMasks(common.IdentityMask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	constr.Encrypt(cand, input)
+
+	c, _ := aes.NewCipher(key)
+	c.Encrypt(real, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestDeriveSeed(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vector from RFC 7914, truncated to SeedSize.
+	real := []byte{0x55, 0xac, 0x04, 0x6e, 0x56, 0xe3, 0x08, 0x9f, 0xec, 0x16, 0x91, 0xc2, 0x25, 0x44, 0xb6, 0x05}
+
+	if cand := DeriveSeed([]byte("passwd"), []byte("salt"), 1); !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// Both of RFC 7914's PBKDF2-HMAC-SHA256 test vectors in full, which take two blocks and, in the second, many
+	// iterations.
+	cases := []struct {
+		password, salt string
+		iterations     int
+		out            string
+	}{
+		{
+			"passwd", "salt", 1,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			"Password", "NaCl", 80000,
+			"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+				"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+
+	for _, cse := range cases {
+		real, _ := hex.DecodeString(cse.out)
+		if cand := pbkdf2([]byte(cse.password), []byte(cse.salt), cse.iterations, len(real)); !bytes.Equal(real, cand) {
+			t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+		}
+	}
+
+	a := DeriveSeed([]byte("password"), []byte("salt 1"), 100)
+	b := DeriveSeed([]byte("password"), []byte("salt 1"), 100)
+	c := DeriveSeed([]byte("password"), []byte("salt 2"), 100)
+
+	if !bytes.Equal(a, b) {
+		t.Fatalf("Same password and salt gave different seeds! %x != %x", a, b)
+	} else if bytes.Equal(a, c) {
+		t.Fatalf("Different salts gave the same seed! %x", a)
+	}
+}
+
+func TestEncryptWithStats(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	in, real := [16]byte{}, [16]byte{}
+	copy(in[:], input)
+	constr.Encrypt(real[:], in[:])
+
+	cand, stats := constr.EncryptWithStats(in)
+	if real != cand {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// Each of the 16 bytes goes through a mask on the way in and out, and each of the 9 rounds looks up one T-Box/Tyi
+	// Table and one MB^(-1) Table per byte. Squashing 16 blocks takes 15 XORs of 32 nibbles, and squashing 4 words takes
+	// 3 XORs of 8 nibbles per column.
+	expected := LookupStats{
+		Mask:      2 * 16,
+		TBoxTyi:   9 * 16,
+		MBInverse: 9 * 16,
+		XOR:       2*15*32 + 9*2*4*3*8,
+	}
+
+	if stats != expected {
+		t.Fatalf("Expected lookup counts disagree with result! %+v != %+v", expected, stats)
+	}
+}
+
+func TestWithLookupHook(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	var kinds []LookupKind
+	hooked := constr.WithLookupHook(func(kind LookupKind, _ byte) { kinds = append(kinds, kind) })
+
+	real, cand := make([]byte, 16), make([]byte, 16)
+	constr.Encrypt(real, input)
+	hooked.Encrypt(cand, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	} else if kinds[0] != MaskLookup || kinds[16] != XORLookup || kinds[len(kinds)-1] != XORLookup {
+		t.Fatalf("Lookups weren't hooked in order! %v", kinds[:17])
+	}
+}
+
+func TestGenerateKeysFromWords(t *testing.T) {
+	words := [4]uint32{0x48656c6c, 0x6f20576f, 0x726c6421, 0x21212121}
+
+	if cand := KeyFromWords(words); !bytes.Equal(key, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", key, cand)
+	}
+
+	cand, real := make([]byte, 16), make([]byte, 16)
+
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	constr2, _, _ := GenerateEncryptionKeysFromWords(words, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	constr1.Encrypt(real, input)
+	constr2.Encrypt(cand, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestWhiteBoxFromAES(t *testing.T) {
+	constr, referenceEncrypt := WhiteBoxFromAES(key, seed)
+	source := random.NewSource("Test", seed)
+	rs := source.Stream(make([]byte, 16))
+
+	for i := 0; i < 16; i++ {
+		in, cand := [16]byte{}, [16]byte{}
+		rs.Read(in[:])
+
+		constr.Encrypt(cand[:], in[:])
+		if real := referenceEncrypt(in); real != cand {
+			t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+		}
+	}
+}
+
 func TestMaskedEncrypt(t *testing.T) {
 	cand, real := make([]byte, 16), make([]byte, 16)
 
@@ -140,38 +618,1138 @@ func TestPersistence(t *testing.T) {
 	}
 }
 
-func BenchmarkGenerateEncryptionKeys(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
-		constr.Serialize()
+func TestGob(t *testing.T) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&constr1); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
 	}
-}
 
-// A "Live" Encryption is one based on table abstractions, so many computations are performed on-demand.
-func BenchmarkLiveEncrypt(b *testing.B) {
-	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	var constr2 Construction
+	if err := gob.NewDecoder(buf).Decode(&constr2); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
 
-	out := make([]byte, 16)
+	cand1, cand2 := make([]byte, 16), make([]byte, 16)
 
-	b.ResetTimer()
+	constr1.Encrypt(cand1, input)
+	constr2.Encrypt(cand2, input)
 
-	for i := 0; i < b.N; i++ {
-		constr.Encrypt(out, input)
+	if !bytes.Equal(cand1, cand2) {
+		t.Fatalf("Real disagrees with decoded! %x != %x", cand1, cand2)
 	}
 }
 
-// A "Dead" Encryption is one based on serialized tables, like we'd have in a real use case.
-func BenchmarkDeadEncrypt(b *testing.B) {
+func TestValidate(t *testing.T) {
 	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
-
 	serialized := constr1.Serialize()
-	constr2, _ := Parse(serialized)
 
-	out := make([]byte, 16)
+	if _, err := Parse(serialized[:len(serialized)-1]); err == nil {
+		t.Fatalf("Parse accepted a truncated construction!")
+	}
 
-	b.ResetTimer()
+	constr2, err := Parse(serialized)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	} else if err := constr2.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
 
-	for i := 0; i < b.N; i++ {
-		constr2.Encrypt(out, input)
+	// Shorten one of the parsed T-Box/Tyi Tables.
+	constr2.TBoxTyiTable[3][5] = table.ParsedWord(serialized[:stepTableSize-1])
+	if err := constr2.Validate(); err == nil {
+		t.Fatalf("Validate accepted a table of the wrong size!")
+	}
+
+	constr2.TBoxTyiTable[3][5] = nil
+	if err := constr2.Validate(); err == nil {
+		t.Fatalf("Validate accepted a missing table!")
+	}
+
+	// Give two inputs of the first HighXORTable that only differ in one nibble the same output.
+	corrupted := append([]byte{}, serialized...)
+	base := common.MagicSize + common.SlicesSize + xorTableSize*32*15 + stepTableSize*9*16
+	corrupted[base] = corrupted[base]&0xf0 | corrupted[base]>>4
+
+	if _, err := Parse(corrupted); err == nil {
+		t.Fatalf("Parse accepted a corrupted XOR table!")
+	} else if err.Error() != "HighXORTable[0 0 0] isn't a bijection of each nibble!" {
+		t.Fatalf("Parse didn't report the corrupted XOR table: %v", err)
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	root := constr.MerkleRoot()
+
+	if len(leaves) != 3008 {
+		t.Fatalf("Wrong number of tables! %v", len(leaves))
+	}
+
+	// The last table is carried up without a sibling, unlike the others.
+	for _, id := range []string{"InputMask[0]", "TBoxTyiTable[3][5]", "LowXORTable[8][31][2]", "OutputXORTables[31][14]"} {
+		data, proof, err := constr.MerkleProof(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !VerifyTable(root, id, data, proof) {
+			t.Fatalf("Valid proof for %v didn't verify!", id)
+		}
+
+		corrupted := append([]byte{}, data...)
+		corrupted[7] ^= 1
+		if VerifyTable(root, id, corrupted, proof) {
+			t.Fatalf("Corrupted table %v verified!", id)
+		}
+	}
+
+	data, proof, _ := constr.MerkleProof("MBInverseTable[0][0]")
+	if VerifyTable(root, "MBInverseTable[0][1]", data, proof) {
+		t.Fatalf("Table verified under the wrong id!")
+	}
+}
+
+func TestDuplex(t *testing.T) {
+	d, inputMask, outputMask := GenerateDuplex(key, seed)
+
+	cand := make([]byte, 16)
+	d.Encrypt(cand, input)
+
+	// Check the ciphertext against AES between the masks.
+	real := make([]byte, 16)
+	c, _ := aes.NewCipher(key)
+	c.Encrypt(real, inputMask.Mul(matrix.Row(input)))
+	real = outputMask.Mul(matrix.Row(real))
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// Decrypt with the same object, before and after a round-trip through serialization.
+	parsed, err := ParseDuplex(d.Serialize())
+	if err != nil {
+		t.Fatalf("ParseDuplex returned error: %v", err)
+	}
+
+	for _, duplex := range []Duplex{d, parsed} {
+		plain := make([]byte, 16)
+		duplex.Decrypt(plain, cand)
+
+		if !bytes.Equal(input, plain) {
+			t.Fatalf("Decryption disagrees with plaintext! %x != %x", input, plain)
+		}
+	}
+
+	// The halves are generated from separate sources, so they don't share any tables.
+	if count := SharedTableCount(&d.Encryption, &d.Decryption); count > 8 {
+		t.Fatalf("Encryption and decryption share %v tables!", count)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	// Budget for the first round and a half, so both flattened and cascaded columns are used.
+	flat := constr.Flatten(6*2*8*squashTableSize + 1000)
+	if flat.High[1][8] == nil || flat.High[1][16] != nil {
+		t.Fatalf("Budget was spent wrong!")
+	}
+
+	real, cand := make([]byte, 16), make([]byte, 16)
+	for i := 0; i < 16; i++ {
+		in := append([]byte{}, input...)
+		in[i] ^= byte(i)
+
+		constr.Encrypt(real, in)
+		flat.Encrypt(cand, in)
+
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+		}
+	}
+}
+
+func BenchmarkGenerateEncryptionKeys(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+		constr.Serialize()
+	}
+}
+
+// A "Live" Encryption is one based on table abstractions, so many computations are performed on-demand.
+func BenchmarkLiveEncrypt(b *testing.B) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	out := make([]byte, 16)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		constr.Encrypt(out, input)
+	}
+}
+
+// Many "Dead" Encryptions, one block at a time.
+func BenchmarkDeadEncryptBlocks(b *testing.B) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	constr2, _ := Parse(constr1.Serialize())
+
+	blocks := make([][16]byte, 64)
+	out := make([]byte, 16)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := range blocks {
+			constr2.Encrypt(out, blocks[j][:])
+		}
+	}
+}
+
+// Many "Dead" Encryptions, in one batch.
+func BenchmarkDeadEncryptMany(b *testing.B) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	constr2, _ := Parse(constr1.Serialize())
+
+	blocks := make([][16]byte, 64)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		constr2.EncryptMany(blocks)
+	}
+}
+
+// A "Dead" Encryption is one based on serialized tables, like we'd have in a real use case.
+func BenchmarkDeadEncrypt(b *testing.B) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	serialized := constr1.Serialize()
+	constr2, _ := Parse(serialized)
+
+	out := make([]byte, 16)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		constr2.Encrypt(out, input)
+	}
+}
+
+// The cost of the external mask stages, as the difference between dead encryptions with and without them.
+func BenchmarkExternalMasks(b *testing.B) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	constr2, _ := Parse(constr1.Serialize())
+
+	block := make([]byte, 16)
+
+	b.Run("With", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			constr2.Encrypt(block, block)
+		}
+	})
+
+	b.Run("Without", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			constr2.rounds(block, constr2.shiftRows, 9)
+			constr2.shiftRows(block)
+		}
+	})
+}
+
+// A "Flattened" Encryption is a dead encryption with every XOR cascade in the rounds replaced by a SquashTable.
+func BenchmarkFlattenedEncrypt(b *testing.B) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	serialized := constr1.Serialize()
+	constr2, _ := Parse(serialized)
+	flat := constr2.Flatten(9 * 2 * 32 * squashTableSize)
+
+	out := make([]byte, 16)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		flat.Encrypt(out, input)
+	}
+}
+
+func TestApplyInputMask(t *testing.T) {
+	source := random.NewSource("Test", seed)
+	mask := source.Matrix(make([]byte, 16), 128)
+	maskInv, _ := mask.Invert()
+
+	masks, masksInv := [16]table.Block{}, [16]table.Block{}
+	for pos := 0; pos < 16; pos++ {
+		masks[pos] = common.BlockMatrix{Linear: mask, Position: pos}
+		masksInv[pos] = common.BlockMatrix{Linear: maskInv, Position: pos}
+	}
+
+	in := [16]byte{}
+	copy(in[:], input)
+
+	masked := ApplyInputMask(masks, in)
+	if real := mask.Mul(matrix.Row(input)); !bytes.Equal(real, masked[:]) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, masked)
+	} else if cand := ApplyInputMask(masksInv, masked); cand != in {
+		t.Fatalf("Inverse mask didn't recover the input! %x != %x", in, cand)
+	}
+}
+
+func TestGenerationIsDeterministic(t *testing.T) {
+	opts := common.IndependentMasks{common.RandomMask, common.RandomMask}
+	real, _, _ := GenerateEncryptionKeys(key, seed, opts)
+	realHash := sha256.Sum256(real.Serialize())
+
+	// The hash of the construction generated from the test key and seed. It's fixed, so a change to anything key
+	// generation depends on that reorders or changes its randomness is caught.
+	const golden = "d677f9d3ed21fa669ae5b3ab395d31f924411cfba6f648452f6dbd167dc74c1a"
+	if hex.EncodeToString(realHash[:]) != golden {
+		t.Fatalf("Generation disagrees with the golden hash! %x != %v", realHash, golden)
+	}
+
+	// Generating concurrently shouldn't change anything either.
+	hashes := make(chan [32]byte, 4)
+	for i := 0; i < cap(hashes); i++ {
+		go func() {
+			cand, _, _ := GenerateEncryptionKeys(key, seed, opts)
+			hashes <- sha256.Sum256(cand.Serialize())
+		}()
+	}
+
+	for i := 0; i < cap(hashes); i++ {
+		if cand := <-hashes; cand != realHash {
+			t.Fatalf("Generation isn't deterministic! %x != %x", realHash, cand)
+		}
+	}
+}
+
+func TestGenerateKeysProfiled(t *testing.T) {
+	constr, stats, err := GenerateKeysProfiled(key, seed)
+	real, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	if err != nil {
+		t.Fatal(err)
+	} else if err := constr.Validate(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(real.Serialize(), constr.Serialize()) {
+		t.Fatalf("Profiled construction disagrees with unprofiled construction!")
+	}
+
+	if stats.Encodings <= 0 || stats.Inversion <= 0 || stats.Tables <= 0 {
+		t.Fatalf("GenStats wasn't populated! %+v", stats)
+	}
+}
+
+func TestExportC(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	buf := &bytes.Buffer{}
+	if err := ExportC(&constr, buf, "wb"); err != nil {
+		t.Fatal(err)
+	}
+	header := buf.String()
+
+	for _, decl := range []string{
+		"static const uint8_t wb_InputMask_0[4096] = {",
+		"static const uint8_t wb_TBoxTyiTable_3_5[1024] = {",
+		"static const uint8_t wb_OutputXORTables_31_14[128] = {",
+	} {
+		if !strings.Contains(header, decl) {
+			t.Fatalf("Header is missing declaration %q!", decl)
+		}
+	}
+
+	// Every table should be one declaration, with its braces balanced.
+	if count := strings.Count(header, "static const uint8_t "); count != 3008 {
+		t.Fatalf("Header has %v arrays, not 3008!", count)
+	} else if strings.Count(header, "{") != count || strings.Count(header, "};") != count {
+		t.Fatalf("Header's braces aren't balanced!")
+	} else if !strings.HasPrefix(header, "#ifndef WB_H\n") || !strings.HasSuffix(header, "#endif\n") {
+		t.Fatalf("Header isn't wrapped in an include guard!")
+	}
+
+	// The exported array should hold exactly the bytes Serialize writes for its table.
+	id, offset := "TBoxTyiTable[3][5]", common.MagicSize
+	for _, l := range leaves[:leafIndex[id]] {
+		offset += l.size
+	}
+	want := constr.Serialize()[offset : offset+leaves[leafIndex[id]].size]
+
+	decl := "static const uint8_t wb_TBoxTyiTable_3_5[1024] = {"
+	body := header[strings.Index(header, decl)+len(decl):]
+	body = body[:strings.Index(body, "};")]
+
+	got := []byte{}
+	for _, field := range strings.Fields(strings.Replace(body, ",", " ", -1)) {
+		b, err := hex.DecodeString(strings.TrimPrefix(field, "0x"))
+		if err != nil || len(b) != 1 {
+			t.Fatalf("Header has a malformed byte %q!", field)
+		}
+		got = append(got, b[0])
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Exported %v doesn't match its serialization!", id)
+	}
+
+	// Compile the header, if there's a C compiler around to do it.
+	t.Run("Compile", func(t *testing.T) {
+		cc, err := exec.LookPath("cc")
+		if err != nil {
+			t.Skip("No C compiler found!")
+		}
+
+		dir, err := ioutil.TempDir("", "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "wb.h")
+		if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(cc, "-fsyntax-only", "-x", "c", file).CombinedOutput(); err != nil {
+			t.Fatalf("Header doesn't compile: %v\n%s", err, out)
+		}
+	})
+
+	if err := ExportC(&constr, &bytes.Buffer{}, "not valid"); err == nil {
+		t.Fatalf("Invalid prefix was accepted!")
+	}
+}
+
+func TestSharedTableCount(t *testing.T) {
+	a, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+	b, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+
+	if count := SharedTableCount(&a, &b); count != len(leaves) {
+		t.Fatalf("Identical constructions only share %v of %v tables!", count, len(leaves))
+	}
+
+	otherKey, otherSeed := append([]byte{}, key...), append([]byte{}, seed...)
+	otherKey[0] ^= 1
+	otherSeed[0] ^= 1
+
+	c, _, _ := GenerateEncryptionKeys(otherKey, otherSeed, common.SameMasks(common.RandomMask))
+	if count := SharedTableCount(&a, &c); count > 8 {
+		t.Fatalf("Constructions with different keys share %v tables!", count)
+	}
+
+	// Under the same seed, only the T-Box/Tyi Tables and the TBoxOutputMask slices (which hold the last round's T-Boxes)
+	// depend on the key. Each one changes unless the round key bytes in it happen to be the same under both keys.
+	d, _, _ := GenerateEncryptionKeys(otherKey, seed, common.SameMasks(common.RandomMask))
+
+	keys, otherKeys := (&saes.Construction{key}).StretchedKey(), (&saes.Construction{otherKey}).StretchedKey()
+	for k := 0; k < 10; k++ {
+		(&saes.Construction{}).ShiftRows(keys[k])
+		(&saes.Construction{}).ShiftRows(otherKeys[k])
+	}
+
+	tboxes := 0
+	for pos := 0; pos < 16; pos++ {
+		for round := 0; round < 9; round++ {
+			if keys[round][pos] != otherKeys[round][pos] {
+				tboxes++
+			}
+		}
+		if keys[9][pos] != otherKeys[9][pos] || keys[10][pos] != otherKeys[10][pos] {
+			tboxes++
+		}
+	}
+
+	if count := SharedTableCount(&a, &d); count != len(leaves)-tboxes {
+		t.Fatalf("Constructions from the same seed share %v tables, not %v!", count, len(leaves)-tboxes)
+	} else if tboxes < 16 {
+		t.Fatalf("Only %v T-Box tables depend on the changed key byte!", tboxes)
+	}
+}
+
+func TestDecoys(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	decoyed := WithDecoys(constr, seed, 2)
+	serialized := decoyed.Serialize()
+	if len(serialized) != common.MagicSize+3*len(constr.Serialize()) {
+		t.Fatalf("Decoys didn't increase the serialized size! %v bytes", len(serialized))
+	}
+
+	parsed, err := ParseDecoyed(serialized, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	real, cand := make([]byte, 16), make([]byte, 16)
+	c.Encrypt(real, input)
+
+	for _, block := range []Decoyed{decoyed, parsed} {
+		block.Encrypt(cand, input)
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+		}
+	}
+}
+
+func TestKeyCommitment(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+	committed := Commit(constr, key, seed)
+
+	parsed, err := ParseCommitted(committed.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongKey := append([]byte{}, key...)
+	wrongKey[0] ^= 1
+
+	for _, c := range []Committed{committed, parsed} {
+		if c.KeyCommitment() != KeyCommitment(key, c.Salt[:]) || !c.VerifyKey(key) {
+			t.Fatalf("Commitment doesn't match the right key!")
+		} else if c.KeyCommitment() == KeyCommitment(wrongKey, c.Salt[:]) || c.VerifyKey(wrongKey) {
+			t.Fatalf("Commitment matches the wrong key!")
+		}
+	}
+}
+
+func TestWalkEncodings(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+
+	count := make(map[string]int)
+	constr.WalkEncodings(func(kind string, round, position int, enc encoding.Byte) {
+		count[kind]++
+	})
+
+	expected := map[string]int{"InputMask": 16, "TBoxTyiTable": 144, "MBInverseTable": 144, "TBoxOutputMask": 16}
+	for kind, n := range expected {
+		if count[kind] != n {
+			t.Fatalf("WalkEncodings visited %v %v encodings, not %v!", count[kind], kind, n)
+		}
+	}
+
+	// A parsed construction doesn't have any encodings to walk.
+	parsed, _ := Parse(constr.Serialize())
+	parsed.WalkEncodings(func(kind string, round, position int, enc encoding.Byte) {
+		t.Fatalf("WalkEncodings visited an encoding of a parsed construction!")
+	})
+}
+
+func TestBenchmarkExternalOverhead(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+	parsed, _ := Parse(constr.Serialize())
+
+	// Wall-clock timings can come out in either order on a busy machine, so BenchmarkExternalMasks compares them.
+	withMs, withoutMs := BenchmarkExternalOverhead(&parsed)
+	if withMs <= 0 || withoutMs <= 0 {
+		t.Fatalf("Timings aren't positive! %v, %v", withMs, withoutMs)
+	}
+}
+
+func TestWithOutputEncoding(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	source := random.NewSource("Test", seed)
+	enc := encoding.NewBlockLinear(source.Matrix(make([]byte, 16), 128))
+
+	real, cand := [16]byte{}, [16]byte{}
+	c.Encrypt(real[:], input)
+
+	constr.WithOutputEncoding(enc).Encrypt(cand[:], input)
+	if cand = enc.Decode(cand); real != cand {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// The original construction should be unchanged.
+	constr.Encrypt(cand[:], input)
+	if real != cand {
+		t.Fatalf("Original construction was changed! %x != %x", real, cand)
+	}
+}
+
+func TestShards(t *testing.T) {
+	opts := common.SameMasks(common.RandomMask)
+	real, _, _ := GenerateEncryptionKeys(key, seed, opts)
+
+	shards := make([]ShardData, 4)
+	for i := range shards {
+		shard, err := GenerateShard(key, seed, opts, i, len(shards))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Shards can be merged in any order.
+		shards[len(shards)-1-i] = shard
+	}
+
+	cand, err := MergeShards(shards)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(real.Serialize(), cand.Serialize()) {
+		t.Fatalf("Merged construction disagrees with unsharded construction!")
+	}
+
+	if _, err := MergeShards(shards[:3]); err == nil {
+		t.Fatalf("Merged with a missing shard!")
+	} else if _, err := GenerateShard(key, seed, opts, 4, 4); err == nil {
+		t.Fatalf("Generated a shard out of range!")
+	}
+}
+
+// mixColumns is MixColumns as a block encoding.
+type mixColumns struct{}
+
+func (mixColumns) Encode(in [16]byte) [16]byte {
+	constr := saes.Construction{}
+	constr.MixColumns(in[:])
+
+	return in
+}
+
+func (mixColumns) Decode(in [16]byte) [16]byte {
+	constr := saes.Construction{}
+	constr.UnMixColumns(in[:])
+
+	return in
+}
+
+func TestCheckFinalRound(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	if err := constr.CheckFinalRound(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Applying MixColumns after the output mask makes it look like the last round has it.
+	if err := constr.WithOutputEncoding(mixColumns{}).CheckFinalRound(); err == nil {
+		t.Fatalf("CheckFinalRound didn't notice MixColumns in the last round!")
+	}
+}
+
+func TestWriteCAVP(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	buf := &bytes.Buffer{}
+	if err := WriteCAVP(&constr, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parse the vectors back out of the file.
+	fields := make(map[string][]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if parts := strings.SplitN(line, " = ", 2); len(parts) == 2 {
+			fields[parts[0]] = append(fields[parts[0]], parts[1])
+		}
+	}
+
+	if len(fields["COUNT"]) != 128 || len(fields["PLAINTEXT"]) != 128 || len(fields["CIPHERTEXT"]) != 128 {
+		t.Fatalf("File doesn't have 128 vectors!")
+	}
+
+	for i := range fields["COUNT"] {
+		plaintext, _ := hex.DecodeString(fields["PLAINTEXT"][i])
+		ciphertext, _ := hex.DecodeString(fields["CIPHERTEXT"][i])
+
+		real := make([]byte, 16)
+		c.Encrypt(real, plaintext)
+
+		if fields["COUNT"][i] != fmt.Sprint(i) {
+			t.Fatalf("Vector %v has COUNT %v!", i, fields["COUNT"][i])
+		} else if !bytes.Equal(real, ciphertext) {
+			t.Fatalf("Real disagrees with result in vector %v! %x != %x", i, real, ciphertext)
+		}
+	}
+}
+
+func TestRunMCT(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	in := [16]byte{}
+	copy(in[:], input)
+
+	real := in
+	for i := 0; i < 100; i++ {
+		c.Encrypt(real[:], real[:])
+	}
+
+	if cand := RunMCT(&constr, in, 100); real != cand {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestSerializeAligned(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+	copied, _ := Parse(constr.Serialize())
+
+	aligned := constr.SerializeAligned(4096)
+	if len(aligned)%4096 != 0 {
+		t.Fatalf("Aligned serialization is %v bytes, which isn't a multiple of the page size!", len(aligned))
+	}
+
+	mapped, err := ParseMapped(aligned)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(copied.Serialize(), mapped.Serialize()) {
+		t.Fatalf("Mapped construction disagrees with copied construction!")
+	}
+
+	real, cand := make([]byte, 16), make([]byte, 16)
+	copied.Encrypt(real, input)
+	mapped.Encrypt(cand, input)
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// The tables should point into the input, starting on a page boundary.
+	if slice := mapped.InputMask[0].(table.ParsedBlock); &slice[0] != &aligned[4096] {
+		t.Fatalf("Mapped table isn't a page-aligned slice of the input!")
+	}
+
+	if _, err := ParseMapped(aligned[:len(aligned)-4096]); err == nil {
+		t.Fatalf("Truncated serialization was parsed!")
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.RandomMask))
+
+	// Word tables' outputs are written byte by byte, in the order Get returns them.
+	serialized, base := constr.Serialize(), common.MagicSize+common.SlicesSize+xorTableSize*32*15
+	for x := 0; x < 256; x++ {
+		real := constr.TBoxTyiTable[0][0].Get(byte(x))
+		if cand := serialized[base+4*x : base+4*x+4]; !bytes.Equal(real[:], cand) {
+			t.Fatalf("Output %x of a Word table is serialized out of order! %x != %x", x, real, cand)
+		}
+	}
+
+	aligned, header := constr.SerializeAligned(4096), common.MagicSize
+	if !bytes.Equal(aligned[header:header+4], []byte{0x00, 0x00, 0x10, 0x00}) {
+		t.Fatalf("Page size header isn't big-endian! %x", aligned[header:header+4])
+	}
+
+	binary.LittleEndian.PutUint32(aligned[header:], 4096)
+	if _, err := ParseMapped(aligned); err == nil || !strings.Contains(err.Error(), "little-endian") {
+		t.Fatalf("Little-endian page size header wasn't rejected as such! %v", err)
+	}
+}
+
+func TestExportFlat(t *testing.T) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	flat := constr1.ExportFlat()
+
+	offsets, _ := flatOffsets()
+	for i, offset := range offsets {
+		if offset%flatAlignment != 0 {
+			t.Fatalf("Run of tables %v starts at %v, which isn't aligned!", i, offset)
+		}
+	}
+
+	constr2, err := ParseFlat(flat)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(constr1.Serialize(), constr2.Serialize()) {
+		t.Fatalf("Parsed construction is not equal to the original!")
+	}
+
+	flat[common.MagicSize+3]++
+	if _, err := ParseFlat(flat); err == nil {
+		t.Fatalf("Flat export with a bad header was parsed!")
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	// Serializations from before the magic header are the same, without it.
+	constr2, err := Parse(constr1.Serialize()[common.MagicSize:])
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(constr1.Serialize(), constr2.Serialize()) {
+		t.Fatalf("Parsed legacy construction is not equal to the original!")
+	}
+
+	if _, err := Parse(constr1.Serialize()[common.MagicSize+1:]); err == nil {
+		t.Fatalf("Parsed a truncated legacy construction!")
+	}
+}
+
+func TestPeekVariant(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	for _, data := range [][]byte{constr.Serialize(), constr.SerializeAligned(4096), constr.SerializeAligned(512)} {
+		if keyBits, rounds, err := PeekVariant(data); err != nil {
+			t.Fatal(err)
+		} else if keyBits != 128 || rounds != 10 {
+			t.Fatalf("PeekVariant returned AES-%v with %v rounds, not AES-128 with 10!", keyBits, rounds)
+		}
+	}
+
+	if _, _, err := PeekVariant([]byte("garbage")); err == nil {
+		t.Fatalf("PeekVariant recognized garbage!")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	real, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	cand, _, _, err := NewBuilder().WithKey(key).WithSeed(seed).Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(real.Serialize(), cand.Serialize()) {
+		t.Fatalf("Builder with defaults disagrees with GenerateEncryptionKeys!")
+	}
+
+	real, _, _ = GenerateDecryptionKeys(key, seed, common.SameMasks(common.IdentityMask), RejectDegenerateShuffles())
+	cand, _, _, err = NewBuilder().WithKey(key).WithSeed(seed).ForDecryption().
+		WithMasks(common.SameMasks(common.IdentityMask)).WithOptions(RejectDegenerateShuffles()).Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(real.Serialize(), cand.Serialize()) {
+		t.Fatalf("Builder disagrees with GenerateDecryptionKeys!")
+	}
+
+	if _, _, _, err := NewBuilder().WithSeed(seed).Build(); err == nil {
+		t.Fatalf("Built a construction without a key!")
+	}
+}
+
+func TestTBox(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	aes := saes.Construction{key}
+	roundKeys := aes.StretchedKey()
+	for k := 0; k < 10; k++ {
+		aes.ShiftRows(roundKeys[k])
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			cand := constr.TBox(round, pos)
+
+			for x := 0; x < 256; x++ {
+				if real := aes.SubByte(byte(x) ^ roundKeys[round][pos]); real != cand[x] {
+					t.Fatalf("T-Box [%v][%v] is wrong at %x! %x != %x", round, pos, x, real, cand[x])
+				}
+			}
+		}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		cand, final := constr.TBox(9, pos), FinalRoundTable(key, pos)
+
+		for x := 0; x < 256; x++ {
+			if real := final.Get(byte(x)); real != cand[x] {
+				t.Fatalf("Last round's T-Box [%v] is wrong at %x! %x != %x", pos, x, real, cand[x])
+			}
+		}
+	}
+}
+
+func TestEncryptUpTo(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	in := [16]byte{}
+	copy(in[:], input)
+
+	full, real := constr.EncryptUpTo(in, 10), [16]byte{}
+	constr.Encrypt(real[:], in[:])
+	if full != real {
+		t.Fatalf("EncryptUpTo(10) is not Encrypt!\nreal=%x\ncand=%x", real, full)
+	}
+
+	// decode strips the encodings off of the state after the given round, with the input encodings of the tables that
+	// read it next.
+	decode := func(round int, state [16]byte) (out [16]byte) {
+		for pos := 0; pos < 16; pos++ {
+			var in encoding.Byte
+			if round < 9 {
+				in = constr.TBoxTyiTable[round][pos].(encoding.WordTable).In
+			} else {
+				in = constr.TBoxOutputMask[pos].(encoding.BlockTable).In
+			}
+
+			from := common.UnShiftRows(pos)
+			out[from] = in.Decode(state[from])
+		}
+
+		return
+	}
+
+	// Trace software AES. The construction adds each round key at the start of the next round, so its state after n
+	// rounds is AES' state after n rounds without the last round key.
+	aes := saes.Construction{key}
+	roundKeys := aes.StretchedKey()
+
+	state := in
+	for round := 0; round < 10; round++ {
+		if cand := decode(round, constr.EncryptUpTo(in, round)); cand != state {
+			t.Fatalf("State after %v rounds is wrong!\nreal=%x\ncand=%x", round, state, cand)
+		}
+
+		aes.AddRoundKey(roundKeys[round], state[:])
+		aes.SubBytes(state[:])
+		aes.ShiftRows(state[:])
+		aes.MixColumns(state[:])
+	}
+}
+
+func TestEncodeInput(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	in := [16]byte{}
+	copy(in[:], input)
+
+	real := [16]byte{}
+	constr.Encrypt(real[:], in[:])
+
+	cand := constr.EncodeInput(in)
+	constr.core(cand[:], constr.shiftRows)
+
+	if real != cand {
+		t.Fatalf("EncodeInput and the core disagree with Encrypt!\nreal=%x\ncand=%x", real, cand)
+	}
+}
+
+func TestSerializeCompressed(t *testing.T) {
+	constr1, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	compressed := constr1.SerializeCompressed()
+	if len(compressed) >= serializedSize {
+		t.Fatalf("Compressed serialization isn't smaller! %v >= %v", len(compressed), serializedSize)
+	}
+
+	constr2, err := ParseCompressed(compressed)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(constr1.Serialize(), constr2.Serialize()) {
+		t.Fatalf("Parsed construction is not equal to the original!")
+	}
+
+	if _, err := ParseCompressed(compressed[:len(compressed)/2]); err == nil {
+		t.Fatalf("Parsed a truncated compressed construction!")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	keyB := []byte{87, 104, 105, 116, 101, 32, 66, 111, 120, 32, 83, 116, 97, 103, 101, 50}
+	opts := common.IndependentMasks{common.RandomMask, common.RandomMask}
+
+	enc, _, _ := GenerateEncryptionKeys(key, seed, opts)
+	dec, _, _ := GenerateDecryptionKeys(key, seed, opts)
+	realEnc, _, _ := GenerateEncryptionKeys(keyB, seed, opts)
+	realDec, _, _ := GenerateDecryptionKeys(keyB, seed, opts)
+
+	for _, c := range []struct {
+		name      string
+		old, real Construction
+	}{{"encryption", enc, realEnc}, {"decryption", dec, realDec}} {
+		cand, err := c.old.Rekey(keyB)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(c.real.Serialize(), cand.Serialize()) {
+			t.Fatalf("Rekeyed %v construction disagrees with a generated one!", c.name)
+		}
+	}
+
+	parsed, _ := Parse(enc.Serialize())
+	if _, err := parsed.Rekey(keyB); err == nil {
+		t.Fatalf("Rekeyed a parsed construction!")
+	}
+}
+
+func TestCheckEncodingCancellation(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	if err := constr.CheckEncodingCancellation(); err != nil {
+		t.Fatalf("Correctly generated construction failed: %v", err)
+	}
+
+	// Replace one XOR gate's output encoding with one the next gate doesn't expect.
+	bad := constr.HighXORTable[4][7][1].(encoding.NibbleTable)
+	bad.Out = encoding.IdentityByte{}
+	constr.HighXORTable[4][7][1] = bad
+
+	if err := constr.CheckEncodingCancellation(); err == nil {
+		t.Fatalf("Construction with a mismatched gate encoding passed!")
+	}
+}
+
+func TestEncodingSkeleton(t *testing.T) {
+	keyB := []byte{87, 104, 105, 116, 101, 32, 66, 111, 120, 32, 83, 116, 97, 103, 101, 50}
+	opts := common.IndependentMasks{common.RandomMask, common.RandomMask}
+
+	constr, _, _ := GenerateDecryptionKeys(key, seed, opts)
+	real, _, _ := GenerateDecryptionKeys(keyB, seed, opts)
+
+	skeleton, err := constr.EncodingSkeleton()
+	if err != nil {
+		t.Fatal(err)
+	} else if hidden := skeleton.constr.TBoxTyiTable[3][5].(encoding.WordTable).Hidden; hidden != nil {
+		t.Fatalf("Skeleton still has a T-Box!")
+	}
+
+	for _, c := range []struct {
+		key  []byte
+		real Construction
+	}{{key, constr}, {keyB, real}} {
+		cand, err := skeleton.WithKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(c.real.Serialize(), cand.Serialize()) {
+			t.Fatalf("Skeleton with key %x disagrees with a generated construction!", c.key)
+		}
+	}
+}
+
+func TestDistinctEncodingCount(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	if count := constr.DistinctEncodingCount(); count != encodingSlots {
+		t.Fatalf("Construction has %v distinct encodings, not %v!", count, encodingSlots)
+	}
+
+	// Make one XOR gate reuse the encoding of the gate before it.
+	prev := constr.HighXORTable[4][7][0].(encoding.NibbleTable)
+	dup := constr.HighXORTable[4][7][1].(encoding.NibbleTable)
+	dup.Out = prev.Out
+	constr.HighXORTable[4][7][1] = dup
+
+	if count := constr.DistinctEncodingCount(); count != encodingSlots-1 {
+		t.Fatalf("Construction with a shared encoding has %v distinct encodings, not %v!", count, encodingSlots-1)
+	}
+}
+
+func TestEncryptMany(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	blocks := make([][16]byte, 5)
+	for i := range blocks {
+		copy(blocks[i][:], input)
+		blocks[i][0] ^= byte(i)
+	}
+
+	cands := constr.EncryptMany(blocks)
+	if len(cands) != len(blocks) {
+		t.Fatalf("EncryptMany returned %v blocks, not %v!", len(cands), len(blocks))
+	}
+
+	for i := range blocks {
+		real := [16]byte{}
+		constr.Encrypt(real[:], blocks[i][:])
+
+		if real != cands[i] {
+			t.Fatalf("EncryptMany disagrees with Encrypt on block %v! %x != %x", i, real, cands[i])
+		}
+	}
+}
+
+func TestInvTyiTable(t *testing.T) {
+	for col := 0; col < 4; col++ {
+		for x := 0; x < 256; x++ {
+			// Mix a column with x in one position, and unmix it.
+			column := [4]byte{0x53, 0xca, 0x01, 0xf3}
+			column[col] = byte(x)
+
+			mixed, unmixed := [4]byte{}, [4]byte{}
+			for i := 0; i < 4; i++ {
+				for j, b := range common.TyiTable(i).Get(column[i]) {
+					mixed[j] ^= b
+				}
+			}
+			for i := 0; i < 4; i++ {
+				for j, b := range InvTyiTable(i).Get(mixed[i]) {
+					unmixed[j] ^= b
+				}
+			}
+
+			if column != unmixed {
+				t.Fatalf("InvTyiTable doesn't invert TyiTable! %x != %x", column, unmixed)
+			}
+		}
+	}
+}
+
+func TestAvalancheScore(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	score := AvalancheScore(&constr, 4)
+	if score < 0.45 || score > 0.55 {
+		t.Fatalf("Construction's avalanche score is %v, not close to 0.5!", score)
+	} else if again := AvalancheScore(&constr, 4); again != score {
+		t.Fatalf("Avalanche score isn't deterministic! %v != %v", score, again)
+	}
+}
+
+func TestWithDomain(t *testing.T) {
+	opts := common.IndependentMasks{common.RandomMask, common.RandomMask}
+
+	plain, _, _ := GenerateEncryptionKeys(key, seed, opts)
+	def, _, _ := GenerateEncryptionKeys(key, seed, opts, WithDomain(""))
+	if !bytes.Equal(plain.Serialize(), def.Serialize()) {
+		t.Fatalf("Default domain disagrees with no domain!")
+	}
+
+	a, inputA, outputA := GenerateEncryptionKeys(key, seed, opts, WithDomain("tenant-a"))
+	b, inputB, outputB := GenerateEncryptionKeys(key, seed, opts, WithDomain("tenant-b"))
+	if bytes.Equal(a.Serialize(), b.Serialize()) || bytes.Equal(a.Serialize(), plain.Serialize()) {
+		t.Fatalf("Different domains generated the same construction!")
+	}
+
+	// The external masks are separated by domain too, and SeedEncodings reproduces them.
+	if inputA.Equals(inputB) || outputA.Equals(outputB) {
+		t.Fatalf("Different domains generated the same masks!")
+	} else if se := (SeedEncodings{seed, opts, false, "tenant-a"}); !inputA.Equals(blockToMatrix(se.Input())) || !outputA.Equals(blockToMatrix(se.Output())) {
+		t.Fatalf("SeedEncodings didn't reproduce a domain's masks!")
+	}
+
+	in := [16]byte{}
+	copy(in[:], input)
+	if EncryptForStandardAES(&a, inputA, outputA, in) != EncryptForStandardAES(&b, inputB, outputB, in) {
+		t.Fatalf("Constructions for different domains disagree on AES!")
+	}
+}
+
+func TestWide(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		constr := GenerateWideKeys(key, seed, size)
+		ref := rijndael.Construction{Key: key, Size: size}
+
+		parsed, err := ParseWide(constr.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		in := make([]byte, size)
+		for i := range in {
+			in[i] = byte(37*i + 1)
+		}
+
+		cand, real := make([]byte, size), make([]byte, size)
+		ref.Encrypt(real, in)
+
+		constr.Encrypt(cand, in)
+		if !bytes.Equal(cand, real) {
+			t.Fatalf("%v-byte construction disagrees with Rijndael!\n%x != %x", size, cand, real)
+		}
+
+		parsed.Encrypt(cand, in)
+		if !bytes.Equal(cand, real) {
+			t.Fatalf("Parsed %v-byte construction disagrees with Rijndael!\n%x != %x", size, cand, real)
+		}
+	}
+
+	// A 128-bit block is AES-128.
+	constr, real := GenerateWideKeys(key, seed, 0), make([]byte, 16)
+	cand := make([]byte, 16)
+	constr.Encrypt(cand, input)
+	saes.Construction{key}.Encrypt(real, input)
+	if !bytes.Equal(cand, real) {
+		t.Fatalf("Default block size isn't AES!")
+	}
+
+	wide := GenerateWideKeys(key, seed, 32)
+	data := wide.Serialize()
+	if _, err := ParseWide(data[:len(data)-1]); err == nil {
+		t.Fatalf("ParseWide accepted a truncated construction!")
 	}
 }
@@ -0,0 +1,76 @@
+package chow
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/random"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// commitmentSize is the size in bytes of the salt and commitment appended to a serialized construction.
+const commitmentSize = 16 + sha256.Size
+
+// Committed is a construction along with a salted commitment to the key it embeds, so that whoever holds it can show it
+// was generated from a given key without revealing the key: a verifier who knows the key and salt recomputes it.
+type Committed struct {
+	Construction
+
+	Salt       [16]byte
+	commitment [32]byte
+}
+
+// KeyCommitment returns the commitment to key with the given salt, SHA-256(key || salt).
+func KeyCommitment(key, salt []byte) [32]byte {
+	return sha256.Sum256(append(append([]byte{}, key...), salt...))
+}
+
+// Commit attaches a commitment to key to constr, which must have been generated from key. The salt is derived from
+// seed.
+func Commit(constr Construction, key, seed []byte) (out Committed) {
+	source := random.NewSource("Chow Commitment", seed)
+	source.Stream(make([]byte, 16)).Read(out.Salt[:])
+
+	out.Construction = constr
+	out.commitment = KeyCommitment(key, out.Salt[:])
+
+	return
+}
+
+// KeyCommitment returns the commitment to the key the construction embeds.
+func (c *Committed) KeyCommitment() [32]byte {
+	return c.commitment
+}
+
+// VerifyKey returns true if key is the key the construction committed to.
+func (c *Committed) VerifyKey(key []byte) bool {
+	cand := KeyCommitment(key, c.Salt[:])
+	return subtle.ConstantTimeCompare(cand[:], c.commitment[:]) == 1
+}
+
+// Serialize serializes a committed white-box into a byte slice: common.ChowCommittedMagic, the serialized construction,
+// the salt, and then the commitment.
+func (c *Committed) Serialize() []byte {
+	out := append([]byte(common.ChowCommittedMagic), c.Construction.Serialize()...)
+	return append(append(out, c.Salt[:]...), c.commitment[:]...)
+}
+
+// ParseCommitted parses a byte array into a committed white-box. It returns an error if the byte array doesn't start
+// with common.ChowCommittedMagic or is the wrong size.
+func ParseCommitted(in []byte) (c Committed, err error) {
+	if in, err = common.StripMagic(in, common.ChowCommittedMagic); err != nil {
+		return
+	} else if len(in) != serializedSize+commitmentSize {
+		return c, errors.New("Parsing the key failed!")
+	}
+
+	if c.Construction, err = Parse(in[:serializedSize]); err != nil {
+		return
+	}
+	copy(c.Salt[:], in[serializedSize:])
+	copy(c.commitment[:], in[serializedSize+16:])
+
+	return
+}
@@ -0,0 +1,71 @@
+package chow
+
+import (
+	"encoding/binary"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// daviesMeyerCacheSize is the number of constructions a DaviesMeyer keeps around for reuse.
+const daviesMeyerCacheSize = 16
+
+// DaviesMeyer is the Davies-Meyer compression function built from white-boxed AES, and a Merkle-Damgård hash on top of
+// it. Davies-Meyer keys the cipher with the message block, Compress(h, m) = Enc_m(h) XOR h, so a construction's fixed
+// key can't be used: a construction is generated for each distinct message block instead, with non-determinism from
+// seed, and the most recently generated ones are cached. Since the key is the message, nothing secret is being hidden--this is for
+// exercising constructions like any other block cipher, not for protecting anything.
+type DaviesMeyer struct {
+	seed  []byte
+	cache map[[16]byte]*Construction
+	order [][16]byte // The cached message blocks, oldest first.
+}
+
+// NewDaviesMeyer returns the Davies-Meyer compression function, with constructions generated from seed.
+func NewDaviesMeyer(seed []byte) *DaviesMeyer {
+	return &DaviesMeyer{
+		seed:  append([]byte{}, seed...),
+		cache: make(map[[16]byte]*Construction),
+	}
+}
+
+// Compress returns Enc_m(h) XOR h. If the cache is full, the oldest construction in it is evicted to make room for a
+// new one.
+func (dm *DaviesMeyer) Compress(h, m [16]byte) (out [16]byte) {
+	constr, ok := dm.cache[m]
+	if !ok {
+		if len(dm.order) >= daviesMeyerCacheSize {
+			delete(dm.cache, dm.order[0])
+			dm.order = dm.order[1:]
+		}
+
+		generated, _, _ := GenerateEncryptionKeys(m[:], dm.seed, common.SameMasks(common.IdentityMask))
+		constr = &generated
+		dm.cache[m] = constr
+		dm.order = append(dm.order, m)
+	}
+
+	constr.Encrypt(out[:], h[:])
+	common.XORBlocksInPlace(&out, h)
+
+	return
+}
+
+// Sum returns the Merkle-Damgård hash of msg: msg is padded with a 1 bit, 0 bits, and its length in bits as a 64-bit
+// big-endian integer, up to a multiple of 16 bytes, and its blocks are compressed in order starting from h = 0.
+func (dm *DaviesMeyer) Sum(msg []byte) (h [16]byte) {
+	padded := append(append([]byte{}, msg...), 0x80)
+	for len(padded)%16 != 8 {
+		padded = append(padded, 0x00)
+	}
+	padded = append(padded, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(padded[len(padded)-8:], uint64(len(msg))*8)
+
+	for base := 0; base < len(padded); base += 16 {
+		m := [16]byte{}
+		copy(m[:], padded[base:])
+
+		h = dm.Compress(h, m)
+	}
+
+	return
+}
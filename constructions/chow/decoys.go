@@ -0,0 +1,102 @@
+package chow
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/OpenWhiteBox/primitives/random"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// Decoyed is a construction hidden among decoy constructions. The decoys are white-boxes of random keys, so each one is
+// a well-formed construction of the same size as the real one, and nothing in the serialization marks which is real.
+// The index of the real construction is derived from the seed, which has to be supplied again to parse it.
+type Decoyed struct {
+	Constructions []Construction // The real construction and the decoys, in serialized order.
+
+	real int
+}
+
+// decoyStream returns the stream that the index of the real construction, and the keys and seeds of the decoys, are
+// read from.
+func decoyStream(seed []byte) io.Reader {
+	source := random.NewSource("Chow Decoys", seed)
+	return source.Stream(make([]byte, 16))
+}
+
+// decoyIndex returns the index of the real construction among n decoys.
+func decoyIndex(stream io.Reader, n int) int {
+	buf := make([]byte, 8)
+	stream.Read(buf)
+
+	return int(binary.BigEndian.Uint64(buf) % uint64(n+1))
+}
+
+// WithDecoys hides constr among n decoys generated from seed.
+func WithDecoys(constr Construction, seed []byte, n int) (out Decoyed) {
+	stream := decoyStream(seed)
+	out.real = decoyIndex(stream, n)
+
+	for i := 0; i <= n; i++ {
+		if i == out.real {
+			out.Constructions = append(out.Constructions, constr)
+			continue
+		}
+
+		key, decoySeed := make([]byte, 16), make([]byte, 16)
+		stream.Read(key)
+		stream.Read(decoySeed)
+
+		decoy, _, _ := GenerateEncryptionKeys(key, decoySeed, common.SameMasks(common.RandomMask))
+		out.Constructions = append(out.Constructions, decoy)
+	}
+
+	return
+}
+
+// BlockSize returns the block size of AES. (Necessary to implement cipher.Block.)
+func (d Decoyed) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst with the real construction. Dst and src may point at the same
+// memory.
+func (d Decoyed) Encrypt(dst, src []byte) { d.Constructions[d.real].Encrypt(dst, src) }
+
+// Decrypt decrypts the first block in src into dst with the real construction. Dst and src may point at the same
+// memory.
+func (d Decoyed) Decrypt(dst, src []byte) { d.Constructions[d.real].Decrypt(dst, src) }
+
+// Serialize serializes the real construction and its decoys into a byte slice: common.ChowDecoyedMagic, then each
+// serialized construction in order.
+func (d *Decoyed) Serialize() []byte {
+	out := make([]byte, 0, common.MagicSize+len(d.Constructions)*serializedSize)
+	out = append(out, common.ChowDecoyedMagic...)
+	for _, constr := range d.Constructions {
+		out = append(out, constr.Serialize()...)
+	}
+
+	return out
+}
+
+// ParseDecoyed parses a byte array into a construction hidden among decoys, using the seed it was hidden with to find
+// the real one. It returns an error if the byte array doesn't start with common.ChowDecoyedMagic or is the wrong size.
+func ParseDecoyed(in, seed []byte) (d Decoyed, err error) {
+	if in, err = common.StripMagic(in, common.ChowDecoyedMagic); err != nil {
+		return
+	} else if len(in) == 0 || len(in)%serializedSize != 0 {
+		return d, errors.New("Parsing the key failed!")
+	}
+
+	for base := 0; base < len(in); base += serializedSize {
+		constr, err := Parse(in[base : base+serializedSize])
+		if err != nil {
+			return Decoyed{}, err
+		}
+
+		d.Constructions = append(d.Constructions, constr)
+	}
+	d.real = decoyIndex(decoyStream(seed), len(d.Constructions)-1)
+
+	return
+}
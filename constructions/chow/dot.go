@@ -0,0 +1,57 @@
+package chow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteDOT writes a Graphviz DOT graph of the data flow through the given round (0 to 8) of the construction: each
+// byte of the state is expanded by a T-Box/Tyi Table, each column is squashed by a tree of High XOR Tables, expanded
+// again by the MB^(-1) Tables, and squashed by a tree of Low XOR Tables. The graph only depends on the round's wiring,
+// not on the contents of its tables.
+func (constr *Construction) WriteDOT(w io.Writer, round int) error {
+	if round < 0 || round > 8 {
+		return fmt.Errorf("Round must be between 0 and 8, not %v!", round)
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "digraph round%v {\n", round)
+
+	for pos := 0; pos < 16; pos++ {
+		fmt.Fprintf(out, "\t\"TBoxTyi %v\" [shape=box];\n", pos)
+		fmt.Fprintf(out, "\t\"MBInverse %v\" [shape=box];\n", pos)
+	}
+
+	for _, half := range []string{"High", "Low"} {
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				fmt.Fprintf(out, "\t\"%vXOR %v.%v\" [shape=circle];\n", half, pos, gate)
+			}
+		}
+	}
+
+	// The XOR tree of each nibble XORs the four words of its column together, one at a time.
+	for _, half := range []struct{ name, expand string }{{"High", "TBoxTyi"}, {"Low", "MBInverse"}} {
+		for pos := 0; pos < 32; pos++ {
+			col := pos / 8 * 4
+
+			fmt.Fprintf(out, "\t\"%v %v\" -> \"%vXOR %v.0\";\n", half.expand, col+0, half.name, pos)
+			fmt.Fprintf(out, "\t\"%v %v\" -> \"%vXOR %v.0\";\n", half.expand, col+1, half.name, pos)
+
+			for gate := 1; gate < 3; gate++ {
+				fmt.Fprintf(out, "\t\"%vXOR %v.%v\" -> \"%vXOR %v.%v\";\n", half.name, pos, gate-1, half.name, pos, gate)
+				fmt.Fprintf(out, "\t\"%v %v\" -> \"%vXOR %v.%v\";\n", half.expand, col+gate+1, half.name, pos, gate)
+			}
+		}
+	}
+
+	// The High XOR Tables' output is the input of the MB^(-1) Tables, a byte (two nibbles) at a time.
+	for pos := 0; pos < 32; pos++ {
+		fmt.Fprintf(out, "\t\"HighXOR %v.2\" -> \"MBInverse %v\";\n", pos, pos/2)
+	}
+
+	fmt.Fprintf(out, "}\n")
+
+	return out.Flush()
+}
@@ -0,0 +1,77 @@
+package chow
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// fixedEncodings is an ExternalEncodingProvider with fixed input and output encodings.
+type fixedEncodings struct {
+	input, output encoding.Block
+}
+
+func (fe fixedEncodings) Input() encoding.Block  { return fe.input }
+func (fe fixedEncodings) Output() encoding.Block { return fe.output }
+
+// Duplex is a white-boxed AES that can both encrypt and decrypt. Chow's construction only computes one direction, so it
+// carries an encryption and a decryption construction with inverse masks: Decrypt undoes Encrypt.
+//
+// It's a plain pair: the two constructions are generated from separate random sources and don't have any tables in
+// common, so it takes as much memory as the two constructions do, and a serialized Duplex is just the two serialized
+// constructions. The key schedule is the only thing they share, and it's baked into the key-dependent tables. Every
+// other table is hidden by encodings, and two tables can only be stored once if they're under the same encodings, which
+// would let the values in one direction be matched with the values in the other.
+type Duplex struct {
+	Encryption, Decryption Construction
+}
+
+// GenerateDuplex creates a white-boxed version of AES with given key for both encryption and decryption, with any
+// non-determinism generated by seed. Encrypt computes outputMask·AES(inputMask·x) and Decrypt computes its inverse.
+func GenerateDuplex(key, seed []byte) (out Duplex, inputMask, outputMask matrix.Matrix) {
+	masks := SeedEncodings{seed, common.IndependentMasks{common.RandomMask, common.RandomMask}, false, ""}
+	input, output := masks.Input(), masks.Output()
+
+	out.Encryption, inputMask, outputMask = GenerateEncryptionKeys(key, seed, fixedEncodings{input, output})
+	out.Decryption, _, _ = GenerateDecryptionKeys(key, seed, fixedEncodings{
+		encoding.InverseBlock{output}, encoding.InverseBlock{input},
+	})
+
+	return
+}
+
+// BlockSize returns the block size of AES. (Necessary to implement cipher.Block.)
+func (d Duplex) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (d Duplex) Encrypt(dst, src []byte) { d.Encryption.Encrypt(dst, src) }
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (d Duplex) Decrypt(dst, src []byte) { d.Decryption.Decrypt(dst, src) }
+
+// Serialize serializes a duplex white-box into a byte slice: common.ChowDuplexMagic, then the serialized encryption and
+// decryption constructions.
+func (d *Duplex) Serialize() []byte {
+	out := append([]byte(common.ChowDuplexMagic), d.Encryption.Serialize()...)
+	return append(out, d.Decryption.Serialize()...)
+}
+
+// ParseDuplex parses a byte array into a duplex white-box. It returns an error if the byte array doesn't start with
+// common.ChowDuplexMagic or is the wrong size.
+func ParseDuplex(in []byte) (d Duplex, err error) {
+	if in, err = common.StripMagic(in, common.ChowDuplexMagic); err != nil {
+		return
+	} else if len(in) != 2*serializedSize {
+		return d, errors.New("Parsing the key failed!")
+	}
+
+	if d.Encryption, err = Parse(in[:serializedSize]); err != nil {
+		return
+	}
+	d.Decryption, err = Parse(in[serializedSize:])
+
+	return
+}
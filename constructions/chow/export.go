@@ -0,0 +1,47 @@
+package chow
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var cIdentifier = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// ExportC writes the construction's tables to w as a C header of static const uint8_t arrays, one per table, in the
+// order Serialize writes them. A table with id "TBoxTyiTable[3][5]" is named prefix_TBoxTyiTable_3_5, and holds
+// exactly the bytes Serialize would write for it. Prefix must be a valid C identifier.
+func ExportC(constr *Construction, w io.Writer, prefix string) error {
+	if !cIdentifier.MatchString(prefix) {
+		return errors.New("Prefix isn't a valid C identifier!")
+	}
+
+	guard := strings.ToUpper(prefix) + "_H"
+	tables := constr.tables()
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "#ifndef %v\n#define %v\n\n#include <stdint.h>\n", guard, guard)
+
+	for i, l := range leaves {
+		name := strings.NewReplacer("[", "_", "]", "").Replace(l.id)
+		fmt.Fprintf(out, "\nstatic const uint8_t %v_%v[%v] = {", prefix, name, l.size)
+
+		for j, b := range tables[i] {
+			if j%16 == 0 {
+				fmt.Fprintf(out, "\n\t")
+			} else {
+				fmt.Fprintf(out, " ")
+			}
+			fmt.Fprintf(out, "0x%02x,", b)
+		}
+
+		fmt.Fprintf(out, "\n};\n")
+	}
+
+	fmt.Fprintf(out, "\n#endif\n")
+
+	return out.Flush()
+}
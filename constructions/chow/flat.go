@@ -0,0 +1,76 @@
+package chow
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+const (
+	// flatAlignment is the boundary that every run of tables in ExportFlat's output starts on.
+	flatAlignment = 64
+
+	// flatHeaderSize is the length of ExportFlat's header: the magic header and a big-endian offset and length for each
+	// run of tables.
+	flatHeaderSize = common.MagicSize + 8*8
+)
+
+// flatOffsets returns the offset of each run of tables in ExportFlat's output, and the output's total length. Every
+// table is a fixed size, so they're the same for every construction.
+func flatOffsets() (offsets []int, size int) {
+	size = flatHeaderSize
+	for _, length := range sectionSizes {
+		size += (flatAlignment - size%flatAlignment) % flatAlignment
+		offsets = append(offsets, size)
+		size += length
+	}
+
+	return
+}
+
+// ExportFlat serializes the construction into one contiguous buffer for runtimes like WebAssembly that index tables
+// directly instead of parsing them. It starts with common.ChowFlatMagic and then eight big-endian uint32 pairs, the
+// offset and length of each run of tables in the order Serialize writes them, and each run starts on a 64-byte
+// boundary. The offsets are fixed, so a loader can also hard-code them; the header is there to check against.
+func (constr *Construction) ExportFlat() []byte {
+	offsets, size := flatOffsets()
+	out := make([]byte, size)
+	copy(out, common.ChowFlatMagic)
+	pairs := out[common.MagicSize:]
+
+	for i, section := range constr.sections() {
+		binary.BigEndian.PutUint32(pairs[8*i:], uint32(offsets[i]))
+		binary.BigEndian.PutUint32(pairs[8*i+4:], uint32(len(section)))
+		copy(out[offsets[i]:], section)
+	}
+
+	return out
+}
+
+// ParseFlat parses a buffer exported by ExportFlat into a white-box construction. Like ParseMapped, the tables are
+// slices of in rather than copies. It returns an error if the buffer is the wrong size or its header doesn't match the
+// fixed layout.
+func ParseFlat(in []byte) (constr Construction, err error) {
+	offsets, size := flatOffsets()
+	pairs, err := common.StripMagic(in, common.ChowFlatMagic)
+	if err != nil {
+		return constr, err
+	} else if len(in) != size {
+		return constr, errors.New("Parsing the key failed!")
+	}
+
+	for i, length := range sectionSizes {
+		if int(binary.BigEndian.Uint32(pairs[8*i:])) != offsets[i] || int(binary.BigEndian.Uint32(pairs[8*i+4:])) != length {
+			return constr, errors.New("Flat export's header doesn't match its layout!")
+		}
+	}
+
+	i := 0
+	constr.parseSections(func(size int) []byte {
+		i++
+		return in[offsets[i-1] : offsets[i-1]+size]
+	})
+
+	return constr, constr.Validate()
+}
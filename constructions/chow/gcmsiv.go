@@ -0,0 +1,193 @@
+package chow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	gcmsivNonceSize = 12
+	gcmsivTagSize   = 16
+)
+
+// polyvalElement is an element of POLYVAL's field, GF(2^128) mod x^128 + x^127 + x^126 + x^121 + 1. Bit i of the
+// little-endian integer lo + 2^64·hi is the coefficient of x^i.
+type polyvalElement struct {
+	lo, hi uint64
+}
+
+// xInv128 is x^(-128) in POLYVAL's field, which is x^127 + x^124 + x^121 + x^114 + 1.
+var xInv128 = polyvalElement{lo: 1, hi: 1<<63 | 1<<60 | 1<<57 | 1<<50}
+
+func newPolyvalElement(b []byte) polyvalElement {
+	return polyvalElement{binary.LittleEndian.Uint64(b[0:8]), binary.LittleEndian.Uint64(b[8:16])}
+}
+
+func (e polyvalElement) bytes() (out [16]byte) {
+	binary.LittleEndian.PutUint64(out[0:8], e.lo)
+	binary.LittleEndian.PutUint64(out[8:16], e.hi)
+
+	return
+}
+
+// mul returns e·f mod the field polynomial.
+func (e polyvalElement) mul(f polyvalElement) (out polyvalElement) {
+	for i := uint(0); i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = (f.lo >> i) & 1
+		} else {
+			bit = (f.hi >> (i - 64)) & 1
+		}
+
+		if bit == 1 {
+			out.lo, out.hi = out.lo^e.lo, out.hi^e.hi
+		}
+
+		// Multiply e by x, replacing x^128 with x^127 + x^126 + x^121 + 1.
+		carry := e.hi >> 63
+		e.hi, e.lo = e.hi<<1|e.lo>>63, e.lo<<1
+		if carry == 1 {
+			e.hi ^= 1<<63 | 1<<62 | 1<<57
+			e.lo ^= 1
+		}
+	}
+
+	return
+}
+
+// polyval continues computing POLYVAL, from RFC 8452, under the key h from the accumulator s over data, which is
+// zero-padded to a multiple of 16 bytes.
+func polyval(h, s polyvalElement, data []byte) polyvalElement {
+	// POLYVAL multiplies with dot(a, b) = a·b·x^(-128), so fold x^(-128) into h once.
+	h = h.mul(xInv128)
+
+	for len(data) > 0 {
+		block := [16]byte{}
+		data = data[copy(block[:], data):]
+
+		x := newPolyvalElement(block[:])
+		s = polyvalElement{s.lo ^ x.lo, s.hi ^ x.hi}.mul(h)
+	}
+
+	return s
+}
+
+// gcmsiv implements AES-GCM-SIV over a white-boxed block cipher.
+type gcmsiv struct {
+	constr *Construction
+}
+
+// NewGCMSIV returns AES-128-GCM-SIV, from RFC 8452, with constr computing AES under the key-generating key. GCM-SIV's
+// key derivation needs the plain block cipher, so constr must be an encryption construction without external masks.
+//
+// The white-box only protects the key-generating key. Each message is encrypted and authenticated under keys derived
+// from it and the nonce, which are used by a standard AES implementation in memory.
+func NewGCMSIV(constr *Construction) (cipher.AEAD, error) {
+	if constr == nil {
+		return nil, errors.New("Construction must not be nil!")
+	}
+
+	return &gcmsiv{constr}, nil
+}
+
+func (g *gcmsiv) NonceSize() int { return gcmsivNonceSize }
+
+func (g *gcmsiv) Overhead() int { return gcmsivTagSize }
+
+// deriveKeys derives the message authentication and encryption keys for nonce, by encrypting a little-endian counter
+// followed by the nonce under the white-box and keeping the first half of each output.
+func (g *gcmsiv) deriveKeys(nonce []byte) (authKey polyvalElement, encBlock cipher.Block) {
+	derived := make([]byte, 32)
+
+	for i := 0; i < 4; i++ {
+		block := make([]byte, 16)
+		binary.LittleEndian.PutUint32(block, uint32(i))
+		copy(block[4:], nonce)
+
+		g.constr.Encrypt(block, block)
+		copy(derived[8*i:], block[:8])
+	}
+
+	encBlock, _ = aes.NewCipher(derived[16:32])
+	return newPolyvalElement(derived[0:16]), encBlock
+}
+
+// tag computes the tag of plaintext and additionalData.
+func (g *gcmsiv) tag(authKey polyvalElement, encBlock cipher.Block, nonce, plaintext, additionalData []byte) []byte {
+	lengths := make([]byte, 16)
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(plaintext))*8)
+
+	s := polyval(authKey, polyvalElement{}, additionalData)
+	s = polyval(authKey, s, plaintext)
+	s = polyval(authKey, s, lengths)
+
+	out := s.bytes()
+	for i := 0; i < gcmsivNonceSize; i++ {
+		out[i] ^= nonce[i]
+	}
+	out[15] &= 0x7f
+
+	encBlock.Encrypt(out[:], out[:])
+	return out[:]
+}
+
+// ctr XORs src with the keystream for tag into dst. The counter block is the tag with its top bit set, and its first
+// 32 bits are incremented as a little-endian integer.
+func (g *gcmsiv) ctr(encBlock cipher.Block, tag, dst, src []byte) {
+	counter, keystream := make([]byte, 16), make([]byte, 16)
+	copy(counter, tag)
+	counter[15] |= 0x80
+
+	for i := range src {
+		if i%16 == 0 {
+			encBlock.Encrypt(keystream, counter)
+			binary.LittleEndian.PutUint32(counter, binary.LittleEndian.Uint32(counter)+1)
+		}
+
+		dst[i] = src[i] ^ keystream[i%16]
+	}
+}
+
+// Seal encrypts and authenticates plaintext, authenticates additionalData, and appends the result to dst. The nonce
+// must be NonceSize() bytes long. Unlike with GCM, reusing a nonce only reveals whether two messages are the same.
+func (g *gcmsiv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != gcmsivNonceSize {
+		panic("Nonce is the wrong size!")
+	}
+
+	authKey, encBlock := g.deriveKeys(nonce)
+	tag := g.tag(authKey, encBlock, nonce, plaintext, additionalData)
+
+	out := make([]byte, len(plaintext)+gcmsivTagSize)
+	g.ctr(encBlock, tag, out, plaintext)
+	copy(out[len(plaintext):], tag)
+
+	return append(dst, out...)
+}
+
+// Open decrypts and authenticates ciphertext, authenticates additionalData and, if both are authentic, appends the
+// plaintext to dst.
+func (g *gcmsiv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != gcmsivNonceSize {
+		panic("Nonce is the wrong size!")
+	} else if len(ciphertext) < gcmsivTagSize {
+		return nil, errors.New("Ciphertext is the wrong size!")
+	}
+
+	body, tag := ciphertext[:len(ciphertext)-gcmsivTagSize], ciphertext[len(ciphertext)-gcmsivTagSize:]
+	authKey, encBlock := g.deriveKeys(nonce)
+
+	plaintext := make([]byte, len(body))
+	g.ctr(encBlock, tag, plaintext, body)
+
+	if subtle.ConstantTimeCompare(tag, g.tag(authKey, encBlock, nonce, plaintext, additionalData)) != 1 {
+		return nil, errors.New("Authenticating the ciphertext failed!")
+	}
+
+	return append(dst, plaintext...), nil
+}
@@ -1,6 +1,13 @@
 package chow
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
 	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/matrix"
 	"github.com/OpenWhiteBox/primitives/random"
@@ -10,9 +17,113 @@ import (
 	"github.com/OpenWhiteBox/AES/constructions/saes"
 )
 
-func generateKeys(rs *random.Source, opts common.KeyGenerationOpts, out *Construction, inputMask, outputMask *matrix.Matrix, shift func(int) int, skinny func(int) table.Byte, wide func(int, int) table.Word) {
+// Option is an optional setting for GenerateEncryptionKeys or GenerateDecryptionKeys.
+type Option func(*source)
+
+// RejectDegenerateShuffles makes key generation re-derive any nibble encoding that comes out as the identity
+// permutation, instead of embedding it in the white-box where it would hide nothing. Generation only differs from the
+// default if a degenerate encoding is actually derived.
+func RejectDegenerateShuffles() Option {
+	return func(rs *source) { rs.rejectDegenerate = true }
+}
+
+// WithDomain derives all of a construction's randomness from the seed under the given domain, like a tenant's name, so
+// that constructions generated from one seed for different domains don't share any encodings, external masks included.
+// SeedEncodings with the same Domain provides the same masks. The empty domain is the default and generates the same
+// constructions as not passing WithDomain at all.
+func WithDomain(domain string) Option {
+	return func(rs *source) { rs.domain = domain }
+}
+
+// ExternalEncodingProvider supplies the input and output encodings of a construction from somewhere other than the seed,
+// like an HSM or a different KDF. Both encodings must be linear. A provider can be passed to GenerateEncryptionKeys or
+// GenerateDecryptionKeys in place of the usual key generation options.
+type ExternalEncodingProvider interface {
+	Input() encoding.Block
+	Output() encoding.Block
+}
+
+// SeedEncodings is the default ExternalEncodingProvider. It provides the masks that GenerateEncryptionKeys (or
+// GenerateDecryptionKeys, if Decryption is true) derives from Seed when given Opts and WithDomain(Domain).
+type SeedEncodings struct {
+	Seed       []byte
+	Opts       common.KeyGenerationOpts
+	Decryption bool
+	Domain     string
+}
+
+// Input returns the input mask as a linear block encoding.
+func (se SeedEncodings) Input() encoding.Block {
+	inputMask, _ := se.masks()
+	return encoding.NewBlockLinear(inputMask)
+}
+
+// Output returns the output mask as a linear block encoding.
+func (se SeedEncodings) Output() encoding.Block {
+	_, outputMask := se.masks()
+	return encoding.NewBlockLinear(outputMask)
+}
+
+func (se SeedEncodings) masks() (inputMask, outputMask matrix.Matrix) {
+	name := "Chow Encryption"
+	if se.Decryption {
+		name = "Chow Decryption"
+	}
+
+	rs := random.NewSource(domainName(name, se.Domain), se.Seed)
+	common.GenerateMasks(&rs, se.Opts, &inputMask, &outputMask)
+
+	return
+}
+
+// blockToMatrix returns the matrix of the linear block encoding enc, by encoding each basis vector.
+func blockToMatrix(enc encoding.Block) matrix.Matrix {
+	if enc.Encode([16]byte{}) != [16]byte{} {
+		panic("External encodings must be linear!")
+	}
+
+	out := matrix.GenerateEmpty(128, 128)
+
+	for col := 0; col < 128; col++ {
+		in := [16]byte{}
+		in[col/8] = 1 << uint(col%8)
+
+		res := enc.Encode(in)
+		for row := 0; row < 128; row++ {
+			out[row].SetBit(col, matrix.Row(res[:]).GetBit(row) == 1)
+		}
+	}
+
+	return out
+}
+
+// CompatibleBoundary returns true if b's input encoding cancels a's output encoding, where a and b are the providers the
+// first and second construction passed to ChainEncrypt were generated with. (The encodings are baked into a
+// construction's tables with the key, so they can't be compared from the constructions themselves.) Both encodings are
+// linear, so it's enough to evaluate them on each basis vector.
+func CompatibleBoundary(a, b ExternalEncodingProvider) bool {
+	output, input := a.Output(), b.Input()
+
+	for col := 0; col < 128; col++ {
+		in := [16]byte{}
+		in[col/8] = 1 << uint(col%8)
+
+		if input.Encode(output.Encode(in)) != in {
+			return false
+		}
+	}
+
+	return true
+}
+
+// generateKeys fills in out and the masks. It checks ctx before each round, and returns ctx's error if it's done.
+func generateKeys(ctx context.Context, rs *source, opts common.KeyGenerationOpts, out *Construction, inputMask, outputMask *matrix.Matrix, shift func(int) int, skinny func(int) table.Byte, wide func(int, int) table.Word) error {
 	// Generate input and output encodings.
-	common.GenerateMasks(rs, opts, inputMask, outputMask)
+	if provider, ok := opts.(ExternalEncodingProvider); ok {
+		*inputMask, *outputMask = blockToMatrix(provider.Input()), blockToMatrix(provider.Output())
+	} else {
+		common.GenerateMasks(rs.Source, opts, inputMask, outputMask)
+	}
 
 	// Generate the Input Mask slices and XOR tables.
 	for pos := 0; pos < 16; pos++ {
@@ -31,22 +142,26 @@ func generateKeys(rs *random.Source, opts common.KeyGenerationOpts, out *Constru
 
 	// Generate round material.
 	for round := 0; round < 9; round++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		for pos := 0; pos < 16; pos++ {
 			// Generate a word-sized mixing bijection and stick it on the end of the T-Box/Tyi Table.
-			mb := common.MixingBijection(rs, 32, round, pos/4)
+			mb := common.MixingBijection(rs.Source, 32, round, pos/4)
 
 			// Build the T-Box and Tyi Table for this round and position in the state matrix.
 			out.TBoxTyiTable[round][pos] = encoding.WordTable{
 				encoding.ComposedBytes{
-					encoding.NewByteLinear(common.MixingBijection(rs, 8, round-1, pos)),
+					encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round-1, pos)),
 					byteRoundEncoding(rs, round-1, pos, common.Outside, common.NoShift),
 				},
 				encoding.ComposedWords{
 					encoding.ConcatenatedWord{
-						encoding.NewByteLinear(common.MixingBijection(rs, 8, round, shift(pos/4*4+0))),
-						encoding.NewByteLinear(common.MixingBijection(rs, 8, round, shift(pos/4*4+1))),
-						encoding.NewByteLinear(common.MixingBijection(rs, 8, round, shift(pos/4*4+2))),
-						encoding.NewByteLinear(common.MixingBijection(rs, 8, round, shift(pos/4*4+3))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+0))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+1))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+2))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+3))),
 					},
 					encoding.NewWordLinear(mb),
 					wordStepEncoding(rs, round, pos, common.Inside),
@@ -55,7 +170,7 @@ func generateKeys(rs *random.Source, opts common.KeyGenerationOpts, out *Constru
 			}
 
 			// Encode the inverse of the mixing bijection from above in the MB^(-1) table for this round and position.
-			mbInv, _ := mb.Invert()
+			mbInv := rs.invert(mb)
 
 			out.MBInverseTable[round][pos] = encoding.WordTable{
 				byteRoundEncoding(rs, round, pos, common.Inside, common.NoShift),
@@ -73,7 +188,7 @@ func generateKeys(rs *random.Source, opts common.KeyGenerationOpts, out *Constru
 	for pos := 0; pos < 16; pos++ {
 		out.TBoxOutputMask[pos] = encoding.BlockTable{
 			encoding.ComposedBytes{
-				encoding.NewByteLinear(common.MixingBijection(rs, 8, 8, pos)),
+				encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, 8, pos)),
 				byteRoundEncoding(rs, 8, pos, common.Outside, common.NoShift),
 			},
 			blockMaskEncoding(rs, pos, common.Outside, shift),
@@ -89,14 +204,30 @@ func generateKeys(rs *random.Source, opts common.KeyGenerationOpts, out *Constru
 		xorEncoding(rs, 10, common.Outside),
 		func(position int) encoding.Nibble { return encoding.IdentityByte{} },
 	)
+
+	return nil
 }
 
-// GenerateEncryptionKeys creates a white-boxed version of AES with given key for encryption, with any non-determinism
-// generated by seed. Opts specifies what type of input and output masks we put on the construction and should be in
-// common.{IndependentMasks, SameMasks, MatchingMasks}.
-func GenerateEncryptionKeys(key, seed []byte, opts common.KeyGenerationOpts) (out Construction, inputMask, outputMask matrix.Matrix) {
-	rs := random.NewSource("Chow Encryption", seed)
+// FinalRoundTable returns the unencoded T-Box of the last round of encryption with the given key, for the given position
+// in the state matrix. Unlike the T-Boxes of the other rounds, it isn't followed by a Tyi Table because the last round
+// doesn't have MixColumns; it computes SubBytes between the last two AddRoundKeys. The construction hides it inside
+// TBoxOutputMask.
+func FinalRoundTable(key []byte, position int) table.Byte {
+	constr := saes.Construction{key}
+	roundKeys := constr.StretchedKey()
+	constr.ShiftRows(roundKeys[9])
+
+	return finalRoundTable(constr, roundKeys, position)
+}
 
+// finalRoundTable returns the last round's T-Box, given round keys where the second to last has had ShiftRows applied.
+func finalRoundTable(constr saes.Construction, roundKeys [11][]byte, pos int) table.Byte {
+	return common.TBox{constr, roundKeys[9][pos], roundKeys[10][pos]}
+}
+
+// encryptionTables returns the key-dependent tables of an encryption construction: the last round's T-Boxes, by
+// position, and the other rounds' T-Box/Tyi Tables, by round and position.
+func encryptionTables(key []byte) (skinny func(int) table.Byte, wide func(int, int) table.Word) {
 	constr := saes.Construction{key}
 	roundKeys := constr.StretchedKey()
 
@@ -105,53 +236,255 @@ func GenerateEncryptionKeys(key, seed []byte, opts common.KeyGenerationOpts) (ou
 		constr.ShiftRows(roundKeys[k])
 	}
 
-	skinny := func(pos int) table.Byte {
-		return common.TBox{constr, roundKeys[9][pos], roundKeys[10][pos]}
+	skinny = func(pos int) table.Byte {
+		return finalRoundTable(constr, roundKeys, pos)
 	}
 
-	wide := func(round, pos int) table.Word {
-		return table.ComposedToWord{
+	wide = func(round, pos int) table.Word {
+		return TBoxTyiTable{
 			common.TBox{Constr: constr, KeyByte1: roundKeys[round][pos]},
 			common.TyiTable(pos % 4),
 		}
 	}
 
-	generateKeys(&rs, opts, &out, &inputMask, &outputMask, common.ShiftRows, skinny, wide)
-
 	return
 }
 
-// GenerateDecryptionKeys creates a white-boxed version of AES with given key for decryption, with any non-determinism
-// generated by seed. Opts specifies what type of input and output masks we put on the construction and should be in
-// common.{IndependentMasks, SameMasks, MatchingMasks}.
-func GenerateDecryptionKeys(key, seed []byte, opts common.KeyGenerationOpts) (out Construction, inputMask, outputMask matrix.Matrix) {
-	rs := random.NewSource("Chow Decryption", seed)
-
+// decryptionTables returns the key-dependent tables of a decryption construction, like encryptionTables.
+func decryptionTables(key []byte) (skinny func(int) table.Byte, wide func(int, int) table.Word) {
 	constr := saes.Construction{key}
 	roundKeys := constr.StretchedKey()
 
 	// Last key needs to be unshifted for decryption to work right.
 	constr.UnShiftRows(roundKeys[10])
 
-	skinny := func(pos int) table.Byte {
+	skinny = func(pos int) table.Byte {
 		return common.InvTBox{constr, 0x00, roundKeys[0][pos]}
 	}
 
-	wide := func(round, pos int) table.Word {
+	wide = func(round, pos int) table.Word {
 		if round == 0 {
 			return table.ComposedToWord{
 				common.InvTBox{Constr: constr, KeyByte1: roundKeys[10][pos], KeyByte2: roundKeys[9][pos]},
-				common.InvTyiTable(pos % 4),
+				InvTyiTable(pos),
 			}
 		} else {
 			return table.ComposedToWord{
 				common.InvTBox{Constr: constr, KeyByte2: roundKeys[9-round][pos]},
-				common.InvTyiTable(pos % 4),
+				InvTyiTable(pos),
 			}
 		}
 	}
 
-	generateKeys(&rs, opts, &out, &inputMask, &outputMask, common.UnShiftRows, skinny, wide)
+	return
+}
+
+// GenerateEncryptionKeys creates a white-boxed version of AES with given key for encryption, with any non-determinism
+// generated by seed. Opts specifies what type of input and output masks we put on the construction and should be in
+// common.{IndependentMasks, SameMasks, MatchingMasks} or be an ExternalEncodingProvider. Options are optional settings,
+// like RejectDegenerateShuffles.
+//
+// Generation is deterministic: the same key, seed, opts, and options always give a byte-identical construction and
+// masks, on any platform and with any Go toolchain. All randomness comes from the seed through the primitives module's
+// random source, nothing ranges over a map, and nothing runs concurrently. The output only changes if the primitives
+// module changes how it derives shuffles and matrices, which TestGenerationIsDeterministic checks against a golden hash.
+func GenerateEncryptionKeys(key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix) {
+	out, inputMask, outputMask, _ = GenerateEncryptionKeysContext(context.Background(), key, seed, opts, options...)
+	return
+}
+
+// GenerateEncryptionKeysContext is GenerateEncryptionKeys, except that it stops generating and returns ctx's error if
+// ctx is done before it's finished. Any partial construction is discarded.
+func GenerateEncryptionKeysContext(ctx context.Context, key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix, err error) {
+	rs := newSource("Chow Encryption", seed, options)
+	skinny, wide := encryptionTables(key)
+
+	if err = generateKeys(ctx, rs, opts, &out, &inputMask, &outputMask, common.ShiftRows, skinny, wide); err != nil {
+		return Construction{}, nil, nil, err
+	}
+
+	return
+}
+
+// GenerateDecryptionKeys creates a white-boxed version of AES with given key for decryption, with any non-determinism
+// generated by seed. Opts specifies what type of input and output masks we put on the construction and should be in
+// common.{IndependentMasks, SameMasks, MatchingMasks} or be an ExternalEncodingProvider. Options are optional settings,
+// like RejectDegenerateShuffles.
+func GenerateDecryptionKeys(key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix) {
+	out, inputMask, outputMask, _ = GenerateDecryptionKeysContext(context.Background(), key, seed, opts, options...)
+	return
+}
+
+// GenerateDecryptionKeysContext is GenerateDecryptionKeys, except that it stops generating and returns ctx's error if
+// ctx is done before it's finished. Any partial construction is discarded.
+func GenerateDecryptionKeysContext(ctx context.Context, key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix, err error) {
+	rs := newSource("Chow Decryption", seed, options)
+	skinny, wide := decryptionTables(key)
+
+	if err = generateKeys(ctx, rs, opts, &out, &inputMask, &outputMask, common.UnShiftRows, skinny, wide); err != nil {
+		return Construction{}, nil, nil, err
+	}
+
+	return
+}
+
+// WhiteBoxFromAES creates an unmasked white-boxed version of AES with given key for encryption, with any
+// non-determinism generated by seed, along with a reference function computing AES with the same key. With no external
+// encodings, the two should always agree, which is convenient for test harnesses.
+func WhiteBoxFromAES(key, seed []byte) (*Construction, func([16]byte) [16]byte) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	reference := saes.Construction{append([]byte{}, key...)}
+	referenceEncrypt := func(in [16]byte) (out [16]byte) {
+		reference.Encrypt(out[:], in[:])
+		return
+	}
+
+	return &constr, referenceEncrypt
+}
+
+// KeyFromWords packs an AES key given as four 32-bit words into bytes, big-endian.
+func KeyFromWords(words [4]uint32) []byte {
+	key := make([]byte, 16)
+	for i, word := range words {
+		binary.BigEndian.PutUint32(key[4*i:], word)
+	}
+
+	return key
+}
+
+// GenerateEncryptionKeysFromWords is GenerateEncryptionKeys with the key given as four 32-bit words.
+func GenerateEncryptionKeysFromWords(words [4]uint32, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix) {
+	return GenerateEncryptionKeys(KeyFromWords(words), seed, opts, options...)
+}
+
+// GenerateDecryptionKeysFromWords is GenerateDecryptionKeys with the key given as four 32-bit words.
+func GenerateDecryptionKeysFromWords(words [4]uint32, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix) {
+	return GenerateDecryptionKeys(KeyFromWords(words), seed, opts, options...)
+}
+
+// SeedSize is the length of the seeds returned by DeriveSeed.
+const SeedSize = 16
+
+// DeriveSeed turns a password into a seed for GenerateEncryptionKeys or GenerateDecryptionKeys, with PBKDF2-HMAC-SHA256
+// over the given salt and number of iterations.
+func DeriveSeed(password, salt []byte, iterations int) []byte {
+	if iterations < 1 {
+		panic("Iterations must be positive!")
+	}
+
+	return pbkdf2(password, salt, iterations, SeedSize)
+}
+
+// pbkdf2 returns the first size bytes of PBKDF2-HMAC-SHA256 of password over salt with the given number of iterations,
+// from RFC 8018. It's checked against the test vectors in RFC 7914.
+func pbkdf2(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+	out := make([]byte, 0, size)
+
+	for block := uint32(1); len(out) < size; block++ {
+		// U_1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		// T = U_1 ^ U_2 ^ ... ^ U_c
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		out = append(out, t...)
+	}
+
+	return out[:size]
+}
+
+// MinSeedStrength is the lowest SeedStrength that GenerateEncryptionKeysStrict and GenerateDecryptionKeysStrict accept.
+// It's the strength of a 16-byte seed, so shorter seeds are never accepted.
+const MinSeedStrength = 128
+
+// SeedStrength returns a metric of how strong seed is for generating a white-box: the number of bits in it, less four
+// (the size of a nibble) for every nibble encoding derived from it that degenerates to the identity. A seed that's one
+// byte repeated, like all zeroes, is the first thing an attacker would try, so it has no strength. It returns an error
+// if the seed is empty.
+//
+// The seed is expanded with a PRF, so its bits can't be measured from its bytes; its length is a floor on the work of
+// guessing it if it was chosen at random, and the degenerate encodings are the ones generation actually got from it.
+func SeedStrength(seed []byte) (int, error) {
+	return seedStrength("Chow Encryption", seed)
+}
+
+func seedStrength(name string, seed []byte) (int, error) {
+	if len(seed) == 0 {
+		return 0, errors.New("Seed is empty!")
+	} else if bytes.Count(seed, seed[:1]) == len(seed) {
+		return 0, nil
+	}
+
+	// The encodings don't depend on the key, so generate a throwaway construction just to see which are derived.
+	rs := newSource(name, seed, nil)
+	rs.derived = make(map[string]bool)
+
+	var (
+		out                   Construction
+		inputMask, outputMask matrix.Matrix
+	)
+	generateKeys(context.Background(), rs, common.SameMasks(common.IdentityMask), &out, &inputMask, &outputMask, common.NoShift,
+		func(int) table.Byte { return common.TBox{} }, func(int, int) table.Word { return TBoxTyiTable{} },
+	)
+
+	degenerate := 0
+	for _, isDegenerate := range rs.derived {
+		if isDegenerate {
+			degenerate++
+		}
+	}
+
+	if strength := 8*len(seed) - 4*degenerate; strength > 0 {
+		return strength, nil
+	}
+
+	return 0, nil
+}
+
+// checkSeed returns an error if seed is weaker than MinSeedStrength.
+func checkSeed(name string, seed []byte) error {
+	strength, err := seedStrength(name, seed)
+	if err != nil {
+		return err
+	} else if strength < MinSeedStrength {
+		return errors.New("Seed is too weak!")
+	}
+
+	return nil
+}
+
+// GenerateEncryptionKeysStrict is GenerateEncryptionKeys, except that it returns an error instead of a construction if
+// seed is weaker than MinSeedStrength.
+func GenerateEncryptionKeysStrict(key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix, err error) {
+	if err = checkSeed("Chow Encryption", seed); err != nil {
+		return
+	}
+
+	out, inputMask, outputMask = GenerateEncryptionKeys(key, seed, opts, options...)
+	return
+}
+
+// GenerateDecryptionKeysStrict is GenerateDecryptionKeys, except that it returns an error instead of a construction if
+// seed is weaker than MinSeedStrength.
+func GenerateDecryptionKeysStrict(key, seed []byte, opts common.KeyGenerationOpts, options ...Option) (out Construction, inputMask, outputMask matrix.Matrix, err error) {
+	if err = checkSeed("Chow Decryption", seed); err != nil {
+		return
+	}
 
+	out, inputMask, outputMask = GenerateDecryptionKeys(key, seed, opts, options...)
 	return
 }
@@ -1,6 +1,8 @@
 package chow
 
 import (
+	"time"
+
 	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/matrix"
 	"github.com/OpenWhiteBox/primitives/random"
@@ -25,12 +27,107 @@ func (mbinv mbInverseTable) Get(i byte) (out [4]byte) {
 	return
 }
 
+// source is the random source all of a construction's randomness is derived from. Its Shuffle method shadows the
+// embedded random.Source's, so that degenerate nibble encodings can be rejected if the caller asked for it.
+type source struct {
+	*random.Source
+
+	rejectDegenerate bool
+
+	// domain, if non-empty, separates the randomness derived from one seed by tenant. See WithDomain.
+	domain string
+
+	// derived, if non-nil, records every label a shuffle is derived from and whether that shuffle was degenerate.
+	derived map[string]bool
+
+	// stats, if non-nil, tallies the time spent inverting matrices.
+	stats *GenStats
+}
+
+// invert returns the inverse of m.
+func (rs *source) invert(m matrix.Matrix) matrix.Matrix {
+	start := time.Now()
+	mInv, _ := m.Invert()
+
+	if rs.stats != nil {
+		rs.stats.Inversion += time.Since(start)
+	}
+
+	return mInv
+}
+
+// newSource creates the random source for a construction with the given name and seed, and applies options to it.
+func newSource(name string, seed []byte, options []Option) *source {
+	rs := &source{}
+	for _, option := range options {
+		option(rs)
+	}
+
+	base := random.NewSource(domainName(name, rs.domain), seed)
+	rs.Source = &base
+
+	return rs
+}
+
+// domainName returns the name of the random source for a construction with the given name, in the given domain. The
+// empty domain leaves the name as-is.
+func domainName(name, domain string) string {
+	if domain == "" {
+		return name
+	}
+
+	return name + "/" + domain
+}
+
+// Shuffle returns the nibble encoding derived from label.
+func (rs *source) Shuffle(label []byte) (out encoding.Nibble) {
+	if !rs.rejectDegenerate {
+		out = rs.Source.Shuffle(label)
+	} else {
+		out = nonDegenerateShuffle(label, func(label []byte) encoding.Nibble { return rs.Source.Shuffle(label) })
+	}
+
+	if rs.derived != nil {
+		rs.derived[string(label)] = isDegenerate(out)
+	}
+
+	return
+}
+
+// nonDegenerateShuffle derives a nibble encoding from label with derive. If the encoding is degenerate, the last byte of
+// the label (which no encoding uses) is incremented and the encoding is re-derived, until one that isn't is found. The
+// same label always gives the same encoding, so both sides of a table boundary still agree.
+func nonDegenerateShuffle(label []byte, derive func([]byte) encoding.Nibble) encoding.Nibble {
+	label = append([]byte{}, label...)
+
+	for tries := 0; tries < 256; tries++ {
+		if cand := derive(label); !isDegenerate(cand) {
+			return cand
+		}
+
+		label[15]++
+	}
+
+	panic("Couldn't derive a non-degenerate shuffle!")
+}
+
+// isDegenerate returns true if the nibble encoding is the identity permutation.
+func isDegenerate(enc encoding.Nibble) bool {
+	for x := byte(0); x < 16; x++ {
+		if enc.Encode(x) != x {
+			return false
+		}
+	}
+
+	return true
+}
+
 // maskEncoding produces encodings for the outputs of the InputMask and OutputMask. All randomness is derived from the
 // random source; surface is common.Inside if these will be the masks between InputMask and InputXORTables or
 // common.Outside if they'll be between TBoxOutputMask and OutputXORTables.
 //
 // See constructions/common/keygen_tools.go for information on the function returned.
-func maskEncoding(rs *random.Source, surface common.Surface) func(int, int) encoding.Nibble {
+func maskEncoding(rs *source, surface common.Surface) func(int, int) encoding.Nibble {
 	return func(position, subPosition int) encoding.Nibble {
 		label := make([]byte, 16)
 		label[0], label[1], label[2], label[3], label[4] = 'M', 'E', byte(position), byte(subPosition), byte(surface)
@@ -55,7 +152,7 @@ func maskEncoding(rs *random.Source, surface common.Surface) func(int, int) enco
 //     OutputXORTables (from TBoxOutputMask).
 //
 // See constructions/common/keygen_tools.go for information on the function returned.
-func xorEncoding(rs *random.Source, round int, surface common.Surface) func(int, int) encoding.Nibble {
+func xorEncoding(rs *source, round int, surface common.Surface) func(int, int) encoding.Nibble {
 	return func(position, gate int) encoding.Nibble {
 		label := make([]byte, 16)
 		label[0], label[1], label[2], label[3], label[4] = 'X', byte(round), byte(position), byte(gate), byte(surface)
@@ -74,7 +171,7 @@ func xorEncoding(rs *random.Source, round int, surface common.Surface) func(int,
 // TBoxTyiTable.
 //
 // See constructions/common/keygen_tools.go for information on the function returned.
-func roundEncoding(rs *random.Source, round int, surface common.Surface, shift func(int) int) func(int) encoding.Nibble {
+func roundEncoding(rs *source, round int, surface common.Surface, shift func(int) int) func(int) encoding.Nibble {
 	return func(position int) encoding.Nibble {
 		position = 2*shift(position/2) + position%2
 
@@ -90,7 +187,7 @@ func roundEncoding(rs *random.Source, round int, surface common.Surface, shift f
 //
 // position is the index of the Block table and shift is the permutation that will be applied between this round and the
 // next or noshift if this is an input encoding; the other parameters are explained in MaskEncoding documentation.
-func blockMaskEncoding(rs *random.Source, position int, surface common.Surface, shift func(int) int) encoding.Block {
+func blockMaskEncoding(rs *source, position int, surface common.Surface, shift func(int) int) encoding.Block {
 	out := encoding.ConcatenatedBlock{}
 
 	for i := 0; i < 16; i++ {
@@ -101,7 +198,7 @@ func blockMaskEncoding(rs *random.Source, position int, surface common.Surface,
 
 		if surface == common.Inside {
 			out[i] = encoding.ComposedBytes{
-				encoding.NewByteLinear(common.MixingBijection(rs, 8, -1, shift(i))),
+				encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, -1, shift(i))),
 				out[i],
 			}
 		}
@@ -115,7 +212,7 @@ func blockMaskEncoding(rs *random.Source, position int, surface common.Surface,
 //
 // All randomness is derived from the random source. round is the current round; position is the byte-wise position in
 // the state matrix that's being stretched; subPosition is the nibble-wise position in the Word table's output.
-func stepEncoding(rs *random.Source, round, position, subPosition int, surface common.Surface) encoding.Nibble {
+func stepEncoding(rs *source, round, position, subPosition int, surface common.Surface) encoding.Nibble {
 	if surface == common.Inside {
 		return tyiEncoding(rs, round, position, subPosition)
 	} else {
@@ -125,7 +222,7 @@ func stepEncoding(rs *random.Source, round, position, subPosition int, surface c
 
 // wordStepEncoding concatenates all the step encodings for the full output of a Word table in TBoxTyiTable or
 // MBInverseTable. Function parameters are explained in the StepEncoding documentation.
-func wordStepEncoding(rs *random.Source, round, position int, surface common.Surface) encoding.Word {
+func wordStepEncoding(rs *source, round, position int, surface common.Surface) encoding.Word {
 	out := encoding.ConcatenatedWord{}
 
 	for i := 0; i < 4; i++ {
@@ -142,7 +239,7 @@ func wordStepEncoding(rs *random.Source, round, position int, surface common.Sur
 //
 // All randomness is derived from the random source; round is the current round; position is the byte-wise position in
 // the state matrix being stretched; subPosition is the nibble-wise position in the Word table's output.
-func tyiEncoding(rs *random.Source, round, position, subPosition int) encoding.Nibble {
+func tyiEncoding(rs *source, round, position, subPosition int) encoding.Nibble {
 	label := make([]byte, 16)
 	label[0], label[1], label[2], label[3] = 'T', byte(round), byte(position), byte(subPosition)
 
@@ -153,7 +250,7 @@ func tyiEncoding(rs *random.Source, round, position, subPosition int) encoding.N
 //
 // All randomness is derived from the random source; round is the current round; position is the byte-wise position in
 // the state matrix being stretched; subPosition is the nibble-wise position in the Word table's output.
-func mbInverseEncoding(rs *random.Source, round, position, subPosition int) encoding.Nibble {
+func mbInverseEncoding(rs *source, round, position, subPosition int) encoding.Nibble {
 	label := make([]byte, 16)
 	label[0], label[1], label[2], label[3], label[4] = 'M', 'I', byte(round), byte(position), byte(subPosition)
 
@@ -162,7 +259,7 @@ func mbInverseEncoding(rs *random.Source, round, position, subPosition int) enco
 
 // byteRoundEncoding concatenates all the round encodings for a single byte. Function parameters are explained in
 // RoundEncoding documentation.
-func byteRoundEncoding(rs *random.Source, round, position int, surface common.Surface, shift func(int) int) encoding.Byte {
+func byteRoundEncoding(rs *source, round, position int, surface common.Surface, shift func(int) int) encoding.Byte {
 	return encoding.ConcatenatedByte{
 		roundEncoding(rs, round, surface, shift)(2*position + 0),
 		roundEncoding(rs, round, surface, shift)(2*position + 1),
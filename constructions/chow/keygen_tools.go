@@ -1,45 +1,306 @@
 package chow
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/OpenWhiteBox/primitives/encoding"
-	"github.com/OpenWhiteBox/primitives/random"
 	"github.com/OpenWhiteBox/primitives/table"
 
 	"github.com/OpenWhiteBox/AES/constructions/common"
 )
 
+// TBoxTyiTable is a T-Box composed with a Tyi Table. In one lookup, it computes AddRoundKey and SubBytes on a byte of the
+// state matrix and then that byte's contribution to MixColumns.
+type TBoxTyiTable struct {
+	TBox common.TBox
+	Tyi  common.TyiTable
+}
+
+func (tt TBoxTyiTable) Get(i byte) [4]byte {
+	return tt.Tyi.Get(tt.TBox.Get(i))
+}
+
+var (
+	invTyiTables    [4]table.ParsedWord
+	invTyiTablesSet sync.Once
+)
+
+// InvTyiTable returns the Inverse Tyi Table for the given column of the state matrix: the share of InvMixColumns that
+// comes from the byte in that column. The four tables are computed once and shared by every decryption construction,
+// including the decryption half of a Duplex, instead of multiplying in the field on every lookup.
+func InvTyiTable(position int) table.Word {
+	invTyiTablesSet.Do(func() {
+		for col := range invTyiTables {
+			invTyiTables[col] = table.ParsedWord(table.SerializeWord(common.InvTyiTable(col)))
+		}
+	})
+
+	return invTyiTables[position%4]
+}
+
 // xorTables generates the XOR Tables for squashing the result of a Tyi Table or MB^(-1) Table.
-func xorTables(rs *random.Source, surface common.Surface, shift func(int) int) (out [9][32][3]table.Nibble) {
+func xorTables(rs *source, surface common.Surface, shift func(int) int) (out [9][32][3]table.Nibble) {
 	for round := 0; round < 9; round++ {
 		for pos := 0; pos < 32; pos++ {
-			out[round][pos][0] = encoding.NibbleTable{
-				encoding.ConcatenatedByte{
-					stepEncoding(rs, round, pos/8*4+0, pos%8, surface),
-					stepEncoding(rs, round, pos/8*4+1, pos%8, surface),
-				},
-				xorEncoding(rs, round, surface)(pos, 0),
-				common.NibbleXORTable{},
+			out[round][pos] = xorGates(rs, round, pos, surface, shift)
+		}
+	}
+
+	return
+}
+
+// xorGates generates the three XOR Tables that squash one nibble-wise position of one round's expanded words.
+func xorGates(rs *source, round, pos int, surface common.Surface, shift func(int) int) (out [3]table.Nibble) {
+	out[0] = encoding.NibbleTable{
+		encoding.ConcatenatedByte{
+			stepEncoding(rs, round, pos/8*4+0, pos%8, surface),
+			stepEncoding(rs, round, pos/8*4+1, pos%8, surface),
+		},
+		xorEncoding(rs, round, surface)(pos, 0),
+		common.NibbleXORTable{},
+	}
+
+	out[1] = encoding.NibbleTable{
+		encoding.ConcatenatedByte{
+			xorEncoding(rs, round, surface)(pos, 0),
+			stepEncoding(rs, round, pos/8*4+2, pos%8, surface),
+		},
+		xorEncoding(rs, round, surface)(pos, 1),
+		common.NibbleXORTable{},
+	}
+
+	out[2] = encoding.NibbleTable{
+		encoding.ConcatenatedByte{
+			xorEncoding(rs, round, surface)(pos, 1),
+			stepEncoding(rs, round, pos/8*4+3, pos%8, surface),
+		},
+		roundEncoding(rs, round, surface, shift)(pos),
+		common.NibbleXORTable{},
+	}
+
+	return
+}
+
+// encodedBlockTable is a slice of a mask with an extra linear encoding on its output. It implements table.Block.
+type encodedBlockTable struct {
+	table.Block
+	enc encoding.Block
+}
+
+func (ebt encodedBlockTable) Get(i byte) [16]byte {
+	return ebt.enc.Encode(ebt.Block.Get(i))
+}
+
+// WithOutputEncoding returns a copy of the construction with enc applied after its output mask, so that it computes
+// enc(Encrypt(x)). Only the TBoxOutputMask slices are rebuilt. Enc must be linear, so that it can be pushed through the
+// Output XOR Tables onto each slice.
+//
+// The masks can only be reached inside a generated construction, so it panics if called on one that was parsed from a
+// serialized construction.
+func (constr *Construction) WithOutputEncoding(enc encoding.Block) *Construction {
+	if enc.Encode([16]byte{}) != [16]byte{} {
+		panic("External encodings must be linear!")
+	}
+
+	out := *constr
+	for pos := 0; pos < 16; pos++ {
+		bt, ok := constr.TBoxOutputMask[pos].(encoding.BlockTable)
+		if !ok {
+			panic("Output encoding can only be changed on a generated construction!")
+		}
+
+		bt.Hidden = encodedBlockTable{bt.Hidden, enc}
+		out.TBoxOutputMask[pos] = bt
+	}
+
+	return &out
+}
+
+// TBox returns the unencoded T-Box at the given round (0 to 9) and position of the construction, as a table: in rounds
+// 0 to 8 it's the half of the T-Box/Tyi Table before the Tyi Table, and in round 9 it's the one hidden inside
+// TBoxOutputMask, like FinalRoundTable. It's the T-Box at that point of the construction's state, so its key byte has
+// been through ShiftRows.
+//
+// It's an analysis tool: the T-Boxes can only be reached inside a generated construction, so it panics if called on one
+// that was parsed from a serialized construction.
+func (constr *Construction) TBox(round, position int) (out [256]byte) {
+	if round < 0 || round > 9 {
+		panic("Round must be between 0 and 9!")
+	}
+
+	var tbox table.Byte
+
+	if round < 9 {
+		if wt, ok := constr.TBoxTyiTable[round][position].(encoding.WordTable); ok {
+			switch hidden := wt.Hidden.(type) {
+			case TBoxTyiTable:
+				tbox = hidden.TBox
+			case table.ComposedToWord:
+				tbox = hidden.Heads
 			}
+		}
+	} else if bt, ok := constr.TBoxOutputMask[position].(encoding.BlockTable); ok {
+		if hidden, ok := bt.Hidden.(table.ComposedToBlock); ok {
+			tbox = hidden.Heads
+		}
+	}
+
+	if tbox == nil {
+		panic("T-Boxes can only be read off of a generated construction!")
+	}
+
+	for x := 0; x < 256; x++ {
+		out[x] = tbox.Get(byte(x))
+	}
 
-			out[round][pos][1] = encoding.NibbleTable{
-				encoding.ConcatenatedByte{
-					xorEncoding(rs, round, surface)(pos, 0),
-					stepEncoding(rs, round, pos/8*4+2, pos%8, surface),
-				},
-				xorEncoding(rs, round, surface)(pos, 1),
-				common.NibbleXORTable{},
+	return
+}
+
+// Rekey returns a copy of the construction for newKey with the same encodings: the T-Boxes hidden in its tables are
+// replaced with newKey's, and everything else is kept. It's the same construction GenerateEncryptionKeys (or
+// GenerateDecryptionKeys) would return for newKey and the seed and options the construction was generated with, without
+// generating any encodings. It returns an error if the construction was parsed from a serialized construction, since
+// its T-Boxes are then baked into the encodings.
+//
+// Only rekey when the old key doesn't have to stay secret from whoever holds the new construction. Two constructions
+// with the same encodings and different keys leak the difference between their keys: composing one table with the
+// inverse of its counterpart in the other construction cancels the encodings between them.
+func (constr *Construction) Rekey(newKey []byte) (*Construction, error) {
+	if len(newKey) != 16 {
+		return nil, errors.New("Key must be 16 bytes!")
+	}
+
+	decryption, err := constr.isDecryption()
+	if err != nil {
+		return nil, err
+	}
+
+	skinny, wide := keyTables(newKey, decryption)
+	return constr.withTables(skinny, wide)
+}
+
+// keyTables returns the key-dependent tables of an encryption or decryption construction.
+func keyTables(key []byte, decryption bool) (skinny func(int) table.Byte, wide func(int, int) table.Word) {
+	if decryption {
+		return decryptionTables(key)
+	}
+
+	return encryptionTables(key)
+}
+
+// isDecryption returns whether a generated construction computes decryption, from the T-Box hidden in its first table.
+// It returns an error if the construction was parsed from a serialized construction.
+func (constr *Construction) isDecryption() (bool, error) {
+	if first, ok := constr.TBoxTyiTable[0][0].(encoding.WordTable); ok {
+		switch first.Hidden.(type) {
+		case TBoxTyiTable:
+			return false, nil
+		case table.ComposedToWord:
+			return true, nil
+		}
+	}
+
+	return false, errors.New("Only a generated construction's T-Boxes can be replaced!")
+}
+
+// withTables returns a copy of a generated construction with the tables hidden behind the encodings of its
+// T-Box/Tyi Tables and of its TBoxOutputMask's T-Boxes replaced by wide and skinny. It returns an error if the
+// construction was parsed from a serialized construction.
+func (constr *Construction) withTables(skinny func(int) table.Byte, wide func(int, int) table.Word) (*Construction, error) {
+	out := *constr
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			wt, ok := constr.TBoxTyiTable[round][pos].(encoding.WordTable)
+			if !ok {
+				return nil, errors.New("Only a generated construction's T-Boxes can be replaced!")
 			}
 
-			out[round][pos][2] = encoding.NibbleTable{
-				encoding.ConcatenatedByte{
-					xorEncoding(rs, round, surface)(pos, 1),
-					stepEncoding(rs, round, pos/8*4+3, pos%8, surface),
-				},
-				roundEncoding(rs, round, surface, shift)(pos),
-				common.NibbleXORTable{},
+			wt.Hidden = wide(round, pos)
+			out.TBoxTyiTable[round][pos] = wt
+		}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		bt, ok := constr.TBoxOutputMask[pos].(encoding.BlockTable)
+		if !ok {
+			return nil, errors.New("Only a generated construction's T-Boxes can be replaced!")
+		}
+
+		hidden, ok := bt.Hidden.(table.ComposedToBlock)
+		if !ok {
+			return nil, errors.New("Only a generated construction's T-Boxes can be replaced!")
+		}
+
+		hidden.Heads = skinny(pos)
+		bt.Hidden = hidden
+		out.TBoxOutputMask[pos] = bt
+	}
+
+	return &out, nil
+}
+
+// cancels returns true if next's input encoding decodes the left nibble of its input with the inverse of prev's output
+// encoding, so that the intermediate value between two successive XOR gates is decoded correctly. It's vacuously true
+// if either table was parsed from a serialized construction.
+func cancels(prev, next table.Nibble) bool {
+	prevTable, ok := prev.(encoding.NibbleTable)
+	if !ok {
+		return true
+	}
+	nextTable, ok := next.(encoding.NibbleTable)
+	if !ok {
+		return true
+	}
+	nextIn, ok := nextTable.In.(encoding.ConcatenatedByte)
+	if !ok {
+		return true
+	}
+
+	for x := byte(0); x < 16; x++ {
+		if nextIn.Left.Decode(prevTable.Out.Encode(x)) != x {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckEncodingCancellation checks that each XOR gate's output encoding is cancelled by the input encoding of the gate
+// after it, in every chain of XOR tables. A generation bug that left an encoding uncancelled would only give the wrong
+// output for some inputs, so it checks every intermediate value rather than sampling encryptions. It returns an error
+// naming the first gate whose output isn't decoded correctly.
+//
+// Encodings can only be read off of a generated construction. Tables that were parsed from a serialized construction
+// are skipped.
+func (constr *Construction) CheckEncodingCancellation() error {
+	for pos := 0; pos < 32; pos++ {
+		for gate := 1; gate < 15; gate++ {
+			if !cancels(constr.InputXORTables[pos][gate-1], constr.InputXORTables[pos][gate]) {
+				return fmt.Errorf("InputXORTables[%v][%v]'s output encoding isn't cancelled by the next gate!", pos, gate-1)
+			} else if !cancels(constr.OutputXORTables[pos][gate-1], constr.OutputXORTables[pos][gate]) {
+				return fmt.Errorf("OutputXORTables[%v][%v]'s output encoding isn't cancelled by the next gate!", pos, gate-1)
 			}
 		}
 	}
 
-	return
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 32; pos++ {
+			for gate := 1; gate < 3; gate++ {
+				if !cancels(constr.HighXORTable[round][pos][gate-1], constr.HighXORTable[round][pos][gate]) {
+					return fmt.Errorf(
+						"HighXORTable[%v][%v][%v]'s output encoding isn't cancelled by the next gate!", round, pos, gate-1,
+					)
+				} else if !cancels(constr.LowXORTable[round][pos][gate-1], constr.LowXORTable[round][pos][gate]) {
+					return fmt.Errorf(
+						"LowXORTable[%v][%v][%v]'s output encoding isn't cancelled by the next gate!", round, pos, gate-1,
+					)
+				}
+			}
+		}
+	}
+
+	return nil
 }
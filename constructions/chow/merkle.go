@@ -0,0 +1,189 @@
+package chow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// leaf is one table of a serialized construction, identified like "TBoxTyiTable[3][5]".
+type leaf struct {
+	id   string
+	size int
+}
+
+// leaves lists every table of a construction in the order Serialize writes them.
+var (
+	leaves    = listLeaves()
+	leafIndex = indexLeaves(leaves)
+)
+
+func listLeaves() (out []leaf) {
+	blockMatrix := func(masks, xors string) {
+		for pos := 0; pos < 16; pos++ {
+			out = append(out, leaf{fmt.Sprintf("%v[%v]", masks, pos), maskTableSize})
+		}
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 15; gate++ {
+				out = append(out, leaf{fmt.Sprintf("%v[%v][%v]", xors, pos, gate), xorTableSize})
+			}
+		}
+	}
+
+	round := func(steps, xors string) {
+		for round := 0; round < 9; round++ {
+			for pos := 0; pos < 16; pos++ {
+				out = append(out, leaf{fmt.Sprintf("%v[%v][%v]", steps, round, pos), stepTableSize})
+			}
+		}
+		for round := 0; round < 9; round++ {
+			for pos := 0; pos < 32; pos++ {
+				for gate := 0; gate < 3; gate++ {
+					out = append(out, leaf{fmt.Sprintf("%v[%v][%v][%v]", xors, round, pos, gate), xorTableSize})
+				}
+			}
+		}
+	}
+
+	blockMatrix("InputMask", "InputXORTables")
+	round("TBoxTyiTable", "HighXORTable")
+	round("MBInverseTable", "LowXORTable")
+	blockMatrix("TBoxOutputMask", "OutputXORTables")
+
+	return
+}
+
+func indexLeaves(leaves []leaf) map[string]int {
+	out := make(map[string]int, len(leaves))
+	for i, l := range leaves {
+		out[l.id] = i
+	}
+
+	return out
+}
+
+// tables returns the serialized construction split into its tables, in the same order as leaves.
+func (constr *Construction) tables() (out [][]byte) {
+	serialized, base := make([]byte, fullSize), 0
+	constr.serializeTables(serialized)
+
+	for _, l := range leaves {
+		out = append(out, serialized[base:base+l.size])
+		base += l.size
+	}
+
+	return
+}
+
+func leafHash(id string, data []byte) (out [32]byte) {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write([]byte(id))
+	h.Write([]byte{0x00})
+	h.Write(data)
+	copy(out[:], h.Sum(nil))
+
+	return
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(append([]byte{0x01}, left[:]...), right[:]...))
+}
+
+// merkleTree returns every level of the Merkle tree over the construction's tables, from the leaves up to the root. A
+// node without a sibling is carried up to the next level unchanged.
+func (constr *Construction) merkleTree() (tables [][]byte, levels [][][32]byte) {
+	tables = constr.tables()
+
+	level := make([][32]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = leafHash(l.id, tables[i])
+	}
+	levels = append(levels, level)
+
+	for len(level) > 1 {
+		next := make([][32]byte, (len(level)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(level) {
+				next[i] = nodeHash(level[2*i], level[2*i+1])
+			} else {
+				next[i] = level[2*i]
+			}
+		}
+
+		level = next
+		levels = append(levels, level)
+	}
+
+	return
+}
+
+// MerkleRoot returns the root of a Merkle tree over the construction's serialized tables, so that each table can be
+// verified on its own with VerifyTable.
+func (constr *Construction) MerkleRoot() [32]byte {
+	_, levels := constr.merkleTree()
+	return levels[len(levels)-1][0]
+}
+
+// MerkleProof returns the serialized table with the given id, like "TBoxTyiTable[3][5]", and the proof that it's in
+// the construction's Merkle tree.
+func (constr *Construction) MerkleProof(id string) (data []byte, proof [][32]byte, err error) {
+	idx, ok := leafIndex[id]
+	if !ok {
+		return nil, nil, errors.New("Unknown table!")
+	}
+
+	tables, levels := constr.merkleTree()
+	for _, level := range levels[:len(levels)-1] {
+		if idx^1 < len(level) {
+			proof = append(proof, level[idx^1])
+		}
+		idx /= 2
+	}
+
+	return tables[leafIndex[id]], proof, nil
+}
+
+// VerifyTable returns true if data is the serialized table with the given id in a construction with the given Merkle
+// root, according to proof.
+func VerifyTable(root [32]byte, id string, data []byte, proof [][32]byte) bool {
+	idx, ok := leafIndex[id]
+	if !ok || len(data) != leaves[idx].size {
+		return false
+	}
+
+	hash := leafHash(id, data)
+	for size := len(leaves); size > 1; size = (size + 1) / 2 {
+		if idx^1 < size {
+			if len(proof) == 0 {
+				return false
+			} else if idx%2 == 0 {
+				hash = nodeHash(hash, proof[0])
+			} else {
+				hash = nodeHash(proof[0], hash)
+			}
+
+			proof = proof[1:]
+		}
+
+		idx /= 2
+	}
+
+	return len(proof) == 0 && hash == root
+}
+
+// SharedTableCount returns the number of tables that are byte-for-byte identical between a and b, in the same place.
+// Constructions from the same key and seed share all of them. The XOR tables only depend on the seed, but every other
+// table depends on the key too, so constructions from different seeds should share essentially none.
+func SharedTableCount(a, b *Construction) (count int) {
+	tablesA, tablesB := a.tables(), b.tables()
+
+	for i := range tablesA {
+		if bytes.Equal(tablesA[i], tablesB[i]) {
+			count++
+		}
+	}
+
+	return
+}
@@ -0,0 +1,361 @@
+package chow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// CounterPolicy specifies how the counter block of CTR mode is incremented after each block of keystream.
+type CounterPolicy int
+
+const (
+	// CounterBE128 increments the whole 128-bit counter block as a big-endian integer. This is what crypto/cipher does,
+	// and is the default.
+	CounterBE128 CounterPolicy = iota
+	// CounterLE128 increments the whole 128-bit counter block as a little-endian integer.
+	CounterLE128
+	// CounterBE32 increments only the last 32 bits of the counter block as a big-endian integer, wrapping around without
+	// carrying into the first 96 bits (as in GCM).
+	CounterBE32
+)
+
+// increment adds one to counter according to the policy.
+func (policy CounterPolicy) increment(counter []byte) {
+	switch policy {
+	case CounterBE128:
+		incrementBE(counter)
+	case CounterLE128:
+		for i := 0; i < len(counter); i++ {
+			counter[i]++
+			if counter[i] != 0 {
+				return
+			}
+		}
+	case CounterBE32:
+		incrementBE(counter[len(counter)-4:])
+	default:
+		panic("Unrecognized counter policy!")
+	}
+}
+
+// incrementBE adds one to counter as a big-endian integer, discarding any carry out of the top byte.
+func incrementBE(counter []byte) {
+	for i := len(counter) - 1; i >= 0; i-- {
+		counter[i]++
+		if counter[i] != 0 {
+			return
+		}
+	}
+}
+
+// Resetter is implemented by the streams that NewCTR and NewCFB8 return and by the CBC reader that NewDecryptReader
+// returns, so that one can be reused with a new IV instead of allocating another.
+type Resetter interface {
+	// Reset starts over from iv, as if freshly constructed with the same construction and settings. Any buffered
+	// keystream or state from before is discarded.
+	Reset(iv []byte)
+}
+
+// ctr implements CTR mode over a white-boxed block cipher.
+type ctr struct {
+	constr *Construction
+	policy CounterPolicy
+
+	counter   []byte
+	keystream []byte // Unused keystream from the last encrypted counter block.
+}
+
+// NewCTR returns a cipher.Stream which encrypts or decrypts with constr in counter mode, starting from the counter
+// block iv and incrementing it according to policy. constr must be an encryption construction, and the length of iv
+// must equal the block size. The stream implements Resetter.
+func NewCTR(constr *Construction, iv []byte, policy CounterPolicy) cipher.Stream {
+	if len(iv) != constr.BlockSize() {
+		panic("chow.NewCTR: IV length must equal block size")
+	}
+
+	return &ctr{
+		constr:  constr,
+		policy:  policy,
+		counter: append([]byte{}, iv...),
+	}
+}
+
+// Reset sets the counter block back to iv and discards any unused keystream.
+func (c *ctr) Reset(iv []byte) {
+	if len(iv) != c.constr.BlockSize() {
+		panic("chow.NewCTR: IV length must equal block size")
+	}
+
+	c.counter, c.keystream = append(c.counter[:0], iv...), nil
+}
+
+// XORKeyStream XORs each byte in src with a byte from the keystream and writes the result to dst. Dst and src may
+// point at the same memory.
+func (c *ctr) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("chow: output smaller than input")
+	}
+
+	for i := 0; i < len(src); i++ {
+		if len(c.keystream) == 0 {
+			c.keystream = make([]byte, c.constr.BlockSize())
+			c.constr.Encrypt(c.keystream, c.counter)
+			c.policy.increment(c.counter)
+		}
+
+		dst[i] = src[i] ^ c.keystream[0]
+		c.keystream = c.keystream[1:]
+	}
+}
+
+// CTRGuard creates CTR streams for one construction and records every IV it's given, so that it can refuse to reuse
+// one. Reusing an IV in CTR mode reuses the keystream, so this is a safety net for development. It only knows about
+// the streams created through it: a copy of the construction, another CTRGuard, or another process can reuse an IV
+// without it noticing. It doesn't catch overlapping counter ranges from different IVs either.
+type CTRGuard struct {
+	constr *Construction
+
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+// NewCTRGuarded returns a CTRGuard for constr, which must be an encryption construction. The IVs it records are kept
+// for as long as the guard is.
+func NewCTRGuarded(constr *Construction) *CTRGuard {
+	return &CTRGuard{constr: constr, used: make(map[string]bool)}
+}
+
+// NewCTR is NewCTR with the default counter policy, except that it returns an error if iv has already been used with
+// this guard.
+func (g *CTRGuard) NewCTR(iv []byte) (cipher.Stream, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.used[string(iv)] {
+		return nil, errors.New("IV has already been used with this construction!")
+	}
+
+	stream := NewCTR(g.constr, iv, CounterBE128)
+	g.used[string(iv)] = true
+
+	return stream, nil
+}
+
+// macLabel is encrypted under the white-box to derive the key of the CBC-MAC in SealEtM and OpenEtM, so that the MAC
+// key is distinct from the white-boxed encryption key.
+var macLabel = []byte("chow EtM MAC key")
+
+// SealEtM encrypts and authenticates plaintext, and authenticates aad, with encrypt-then-MAC. The plaintext is PKCS#7
+// padded and encrypted in CBC mode with enc under a random IV, and a CBC-MAC over aad, the IV, and the CBC ciphertext is
+// appended. The output is IV || CBC ciphertext || tag. enc must be an encryption construction.
+func SealEtM(enc *Construction, plaintext, aad []byte) (ciphertext []byte, err error) {
+	padLen := enc.BlockSize() - len(plaintext)%enc.BlockSize()
+
+	ciphertext = make([]byte, enc.BlockSize()+len(plaintext)+padLen, enc.BlockSize()+len(plaintext)+padLen+16)
+	iv, body := ciphertext[:enc.BlockSize()], ciphertext[enc.BlockSize():]
+
+	if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	copy(body, plaintext)
+	for i := len(plaintext); i < len(body); i++ {
+		body[i] = byte(padLen)
+	}
+	cipher.NewCBCEncrypter(enc, iv).CryptBlocks(body, body)
+
+	return append(ciphertext, cbcMAC(enc, ciphertext, aad)...), nil
+}
+
+// OpenEtM checks the tag on a ciphertext produced by SealEtM and, if it and aad are authentic, decrypts it. enc must be
+// the construction that sealed the ciphertext and dec must be a decryption construction that inverts it.
+func OpenEtM(enc, dec *Construction, ciphertext, aad []byte) (plaintext []byte, err error) {
+	if len(ciphertext) < 3*enc.BlockSize() || len(ciphertext)%enc.BlockSize() != 0 {
+		return nil, errors.New("Ciphertext is the wrong size!")
+	}
+
+	body, tag := ciphertext[:len(ciphertext)-16], ciphertext[len(ciphertext)-16:]
+	if subtle.ConstantTimeCompare(tag, cbcMAC(enc, body, aad)) != 1 {
+		return nil, errors.New("Authenticating the ciphertext failed!")
+	}
+
+	iv := body[:dec.BlockSize()]
+	plaintext = make([]byte, len(body)-dec.BlockSize())
+	cipher.NewCBCDecrypter(dec, iv).CryptBlocks(plaintext, body[dec.BlockSize():])
+
+	// The tag is valid, so the padding is only malformed if dec doesn't invert enc.
+	return unpad(plaintext, dec.BlockSize())
+}
+
+// unpad removes PKCS#7 padding from plaintext, whose length is a positive multiple of size.
+func unpad(plaintext []byte, size int) ([]byte, error) {
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen == 0 || padLen > size {
+		return nil, errors.New("Unpadding the plaintext failed!")
+	}
+	for _, b := range plaintext[len(plaintext)-padLen:] {
+		if int(b) != padLen {
+			return nil, errors.New("Unpadding the plaintext failed!")
+		}
+	}
+
+	return plaintext[:len(plaintext)-padLen], nil
+}
+
+// OpenCBCSafe decrypts a ciphertext made of an IV followed by the CBC encryption of a PKCS#7-padded plaintext, with dec
+// a decryption construction. The padding is checked in constant time, and every failure returns the same error, so
+// that whether the padding was valid can't be learned from the result. Unlike OpenEtM, nothing is authenticated.
+func OpenCBCSafe(dec *Construction, ciphertext []byte) ([]byte, error) {
+	size := dec.BlockSize()
+	if len(ciphertext) < 2*size || len(ciphertext)%size != 0 {
+		return nil, errCBCDecrypt
+	}
+
+	plaintext := make([]byte, len(ciphertext)-size)
+	cipher.NewCBCDecrypter(dec, ciphertext[:size]).CryptBlocks(plaintext, ciphertext[size:])
+
+	padLen, good := unpadConstantTime(plaintext, size)
+	if good != 1 {
+		return nil, errCBCDecrypt
+	}
+
+	return plaintext[:len(plaintext)-padLen], nil
+}
+
+// errCBCDecrypt is the only error OpenCBCSafe returns.
+var errCBCDecrypt = errors.New("Decrypting the ciphertext failed!")
+
+// unpadConstantTime returns the length of the PKCS#7 padding on plaintext, and 1 if it's valid or 0 if not. It looks
+// at the whole last block no matter what the padding is.
+func unpadConstantTime(plaintext []byte, size int) (padLen, good int) {
+	last := plaintext[len(plaintext)-size:]
+	padLen = int(last[size-1])
+	good = subtle.ConstantTimeLessOrEq(1, padLen) & subtle.ConstantTimeLessOrEq(padLen, size)
+
+	for i := 0; i < size; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i+1, padLen)
+		matches := subtle.ConstantTimeByteEq(last[size-1-i], byte(padLen))
+
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
+	}
+
+	return
+}
+
+// cbcMAC computes the CBC-MAC of aad and body under the MAC key derived from enc. The lengths of both are MAC'ed first,
+// and aad is zero-padded to a block boundary, so that no two (aad, body) pairs give the same input.
+func cbcMAC(enc *Construction, body, aad []byte) []byte {
+	key := make([]byte, 16)
+	enc.Encrypt(key, macLabel)
+
+	block, _ := aes.NewCipher(key)
+
+	msg := make([]byte, 16, 16+len(aad)+15+len(body))
+	binary.BigEndian.PutUint64(msg[0:8], uint64(len(aad)))
+	binary.BigEndian.PutUint64(msg[8:16], uint64(len(body)))
+
+	msg = append(msg, aad...)
+	if rem := len(aad) % 16; rem != 0 {
+		msg = append(msg, make([]byte, 16-rem)...)
+	}
+	msg = append(msg, body...)
+
+	tag := make([]byte, 16)
+	cipher.NewCBCEncrypter(block, tag).CryptBlocks(msg, msg)
+	copy(tag, msg[len(msg)-16:])
+
+	return tag
+}
+
+// cfb8 implements CFB mode with an 8-bit segment size over a white-boxed block cipher.
+type cfb8 struct {
+	constr  *Construction
+	decrypt bool
+
+	register [16]byte
+}
+
+// NewCFB8 returns a cipher.Stream which encrypts (or decrypts, if decrypt is true) with constr in cipher feedback mode
+// with an 8-bit segment size, one byte at a time. Both directions only use the block cipher's forwards direction, so
+// constr must be an encryption construction either way. The stream implements Resetter.
+func NewCFB8(constr *Construction, iv [16]byte, decrypt bool) cipher.Stream {
+	return &cfb8{constr: constr, decrypt: decrypt, register: iv}
+}
+
+// Reset sets the shift register back to iv, keeping the direction.
+func (c *cfb8) Reset(iv []byte) {
+	if len(iv) != len(c.register) {
+		panic("chow.NewCFB8: IV length must equal block size")
+	}
+
+	copy(c.register[:], iv)
+}
+
+// XORKeyStream XORs each byte in src with a byte from the keystream and writes the result to dst. Dst and src may
+// point at the same memory.
+func (c *cfb8) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("chow: output smaller than input")
+	}
+
+	keystream := make([]byte, 16)
+
+	for i := 0; i < len(src); i++ {
+		c.constr.Encrypt(keystream, c.register[:])
+
+		in := src[i]
+		dst[i] = in ^ keystream[0]
+
+		// Shift the ciphertext byte into the register.
+		feedback := dst[i]
+		if c.decrypt {
+			feedback = in
+		}
+
+		copy(c.register[:], c.register[1:])
+		c.register[15] = feedback
+	}
+}
+
+// SecureCipher only exposes authenticated encryption, with AES-GCM under random nonces, so that callers aren't tempted
+// by ECB or unauthenticated CBC. GCM only ever encrypts with the block cipher, so it needs just one encryption
+// construction, unlike SealEtM and OpenEtM.
+type SecureCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecureCipher returns a SecureCipher using constr, which must be an encryption construction.
+func NewSecureCipher(constr *Construction) (*SecureCipher, error) {
+	aead, err := cipher.NewGCM(constr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecureCipher{aead}, nil
+}
+
+// Seal encrypts and authenticates plaintext, and authenticates aad. The output is nonce || ciphertext || tag, with a
+// random nonce.
+func (sc *SecureCipher) Seal(plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, sc.aead.NonceSize(), sc.aead.NonceSize()+len(plaintext)+sc.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return sc.aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open checks that a ciphertext produced by Seal and aad are authentic and, if they are, decrypts the ciphertext.
+func (sc *SecureCipher) Open(ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < sc.aead.NonceSize()+sc.aead.Overhead() {
+		return nil, errors.New("Ciphertext is the wrong size!")
+	}
+
+	nonce, body := ciphertext[:sc.aead.NonceSize()], ciphertext[sc.aead.NonceSize():]
+	return sc.aead.Open(nil, nonce, body, aad)
+}
@@ -0,0 +1,475 @@
+package chow
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+func TestCTR(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	// The IV is chosen so that each policy carries differently out of the first block.
+	iv := []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}
+
+	cases := []struct {
+		policy CounterPolicy
+		next   []byte
+	}{
+		{CounterBE128, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{CounterLE128, []byte{0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}},
+		{CounterBE32, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, cse := range cases {
+		real := make([]byte, 32)
+		c.Encrypt(real[:16], iv)
+		c.Encrypt(real[16:], cse.next)
+
+		// Encrypt zeroes in pieces that don't line up with block boundaries, to exercise keystream buffering.
+		cand := make([]byte, 32)
+		stream := NewCTR(&constr, iv, cse.policy)
+		stream.XORKeyStream(cand[:7], cand[:7])
+		stream.XORKeyStream(cand[7:23], cand[7:23])
+		stream.XORKeyStream(cand[23:], cand[23:])
+
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Policy %v: real disagrees with result! %x != %x", cse.policy, real, cand)
+		}
+	}
+}
+
+func TestCTRMatchesStdlib(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	src := bytes.Repeat(input, 5)
+	cand, real := make([]byte, len(src)), make([]byte, len(src))
+
+	NewCTR(&constr, seed, CounterBE128).XORKeyStream(cand, src)
+	cipher.NewCTR(c, seed).XORKeyStream(real, src)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestCTRGuarded(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	iv1, iv2 := make([]byte, 16), make([]byte, 16)
+	iv2[15] = 1
+
+	guard := NewCTRGuarded(&constr)
+	if _, err := guard.NewCTR(iv1); err != nil {
+		t.Fatalf("Fresh IV was rejected! %v", err)
+	} else if _, err := guard.NewCTR(iv2); err != nil {
+		t.Fatalf("Fresh IV was rejected! %v", err)
+	} else if _, err := guard.NewCTR(iv1); err == nil {
+		t.Fatalf("Reused IV was accepted!")
+	}
+
+	// Each guard only knows about its own IVs.
+	if _, err := NewCTRGuarded(&constr).NewCTR(iv1); err != nil {
+		t.Fatalf("Another guard rejected an IV it hadn't seen! %v", err)
+	}
+}
+
+func TestEtM(t *testing.T) {
+	enc, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	dec, _, _ := GenerateDecryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	plaintext, aad := bytes.Repeat(input, 3)[:37], []byte("associated data")
+
+	ciphertext, err := SealEtM(&enc, plaintext, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cand, err := OpenEtM(&enc, &dec, ciphertext, aad)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", plaintext, cand)
+	}
+
+	// Tampering with the ciphertext should make Open fail.
+	tampered := append([]byte{}, ciphertext...)
+	tampered[20] ^= 1
+	if _, err := OpenEtM(&enc, &dec, tampered, aad); err == nil {
+		t.Fatalf("Tampered ciphertext was opened!")
+	}
+
+	// Tampering with the associated data should make Open fail.
+	tamperedAAD := append([]byte{}, aad...)
+	tamperedAAD[0] ^= 1
+	if _, err := OpenEtM(&enc, &dec, ciphertext, tamperedAAD); err == nil {
+		t.Fatalf("Ciphertext was opened with tampered associated data!")
+	}
+}
+
+func TestCFB8(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	iv := [16]byte{}
+	copy(iv[:], seed)
+
+	// Compute the real ciphertext with the plaintext key, one segment at a time.
+	plaintext := bytes.Repeat(input, 2)[:21]
+	real, register, keystream := make([]byte, len(plaintext)), iv, make([]byte, 16)
+
+	for i, p := range plaintext {
+		c.Encrypt(keystream, register[:])
+		real[i] = p ^ keystream[0]
+
+		copy(register[:], register[1:])
+		register[15] = real[i]
+	}
+
+	cand := make([]byte, len(plaintext))
+	enc := NewCFB8(&constr, iv, false)
+	enc.XORKeyStream(cand[:5], plaintext[:5])
+	enc.XORKeyStream(cand[5:], plaintext[5:])
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+
+	// Decrypt in place.
+	NewCFB8(&constr, iv, true).XORKeyStream(cand, cand)
+
+	if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Decryption disagrees with plaintext! %x != %x", plaintext, cand)
+	}
+
+	// With a full-block segment size, the first block of CFB8's keystream is stdlib CFB's.
+	stdlib := make([]byte, 1)
+	cipher.NewCFBEncrypter(c, iv[:]).XORKeyStream(stdlib, plaintext[:1])
+
+	if stdlib[0] != real[0] {
+		t.Fatalf("Stdlib disagrees with result on the first byte! %x != %x", stdlib[0], real[0])
+	}
+}
+
+// readChunks reads r to EOF, a few bytes at a time.
+func readChunks(r io.Reader) (out []byte, err error) {
+	buf := make([]byte, 3)
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+	}
+}
+
+func TestDecryptReader(t *testing.T) {
+	enc, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	dec, _, _ := GenerateDecryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	plaintext, iv := bytes.Repeat(input, 3)[:37], seed
+
+	// CBC with PKCS#7 padding.
+	padded := append([]byte{}, plaintext...)
+	padded = append(padded, bytes.Repeat([]byte{11}, 11)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(ciphertext, padded)
+
+	r, err := NewDecryptReader(&dec, "cbc", iv, iotest.OneByteReader(bytes.NewReader(ciphertext)))
+	if err != nil {
+		t.Fatal(err)
+	} else if cand, err := readChunks(r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Real disagrees with result in CBC mode! %x != %x", plaintext, cand)
+	}
+
+	// A truncated ciphertext should fail at EOF.
+	r, _ = NewDecryptReader(&dec, "cbc", iv, bytes.NewReader(ciphertext[:40]))
+	if _, err := readChunks(r); err == nil {
+		t.Fatalf("Truncated ciphertext was decrypted!")
+	}
+
+	// CTR, which isn't padded.
+	ciphertext = make([]byte, len(plaintext))
+	cipher.NewCTR(c, iv).XORKeyStream(ciphertext, plaintext)
+
+	r, err = NewDecryptReader(&enc, "ctr", iv, iotest.OneByteReader(bytes.NewReader(ciphertext)))
+	if err != nil {
+		t.Fatal(err)
+	} else if cand, err := readChunks(r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Real disagrees with result in CTR mode! %x != %x", plaintext, cand)
+	}
+
+	if _, err := NewDecryptReader(&enc, "ecb", iv, bytes.NewReader(ciphertext)); err == nil {
+		t.Fatalf("Unknown mode was accepted!")
+	}
+}
+
+func TestReset(t *testing.T) {
+	enc, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	dec, _, _ := GenerateDecryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	src := bytes.Repeat(input, 3)[:37]
+	iv1, iv2 := make([]byte, 16), append([]byte{}, seed...)
+	block := [16]byte{}
+	copy(block[:], iv2)
+
+	streams := []struct {
+		name  string
+		used  cipher.Stream
+		fresh cipher.Stream
+	}{
+		{"CTR", NewCTR(&enc, iv1, CounterLE128), NewCTR(&enc, iv2, CounterLE128)},
+		{"CFB8", NewCFB8(&enc, [16]byte{}, false), NewCFB8(&enc, block, false)},
+	}
+
+	for _, s := range streams {
+		// Leave some keystream buffered before resetting.
+		junk := make([]byte, 7)
+		s.used.XORKeyStream(junk, junk)
+		s.used.(Resetter).Reset(iv2)
+
+		cand, real := make([]byte, len(src)), make([]byte, len(src))
+		s.used.XORKeyStream(cand, src)
+		s.fresh.XORKeyStream(real, src)
+
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("%v: reset stream disagrees with a fresh one! %x != %x", s.name, real, cand)
+		}
+	}
+
+	// A CBC reader is reset between two ciphertexts read from the same source.
+	padded := append(append([]byte{}, src...), bytes.Repeat([]byte{11}, 11)...)
+	first, second := make([]byte, len(padded)), make([]byte, len(padded))
+	cipher.NewCBCEncrypter(c, iv1).CryptBlocks(first, padded)
+	cipher.NewCBCEncrypter(c, iv2).CryptBlocks(second, padded)
+
+	source := bytes.NewReader(first[:40])
+	r, _ := NewDecryptReader(&dec, "cbc", iv1, source)
+	if _, err := readChunks(r); err == nil {
+		t.Fatalf("Truncated ciphertext was decrypted!")
+	}
+
+	source.Reset(second)
+	r.(Resetter).Reset(iv2)
+	if cand, err := readChunks(r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(src, cand) {
+		t.Fatalf("Reset CBC reader disagrees with plaintext! %x != %x", src, cand)
+	}
+}
+
+func TestOpenCBCSafe(t *testing.T) {
+	dec, _, _ := GenerateDecryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	c, _ := aes.NewCipher(key)
+
+	plaintext := bytes.Repeat(input, 2)[:21]
+	seal := func(padded []byte) []byte {
+		ciphertext := append([]byte{}, seed...)
+		ciphertext = append(ciphertext, make([]byte, len(padded))...)
+		cipher.NewCBCEncrypter(c, seed).CryptBlocks(ciphertext[16:], padded)
+
+		return ciphertext
+	}
+
+	valid := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{11}, 11)...)
+	if cand, err := OpenCBCSafe(&dec, seal(valid)); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", plaintext, cand)
+	}
+
+	// Every kind of invalid padding should get the same error.
+	badLength := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{17}, 11)...)
+	badByte := append([]byte{}, valid...)
+	badByte[25] = 10
+
+	for _, padded := range [][]byte{badLength, badByte} {
+		if _, err := OpenCBCSafe(&dec, seal(padded)); err != errCBCDecrypt {
+			t.Fatalf("Invalid padding returned %v!", err)
+		}
+	}
+	if _, err := OpenCBCSafe(&dec, seal(valid)[:40]); err != errCBCDecrypt {
+		t.Fatalf("Truncated ciphertext returned %v!", err)
+	}
+}
+
+func TestUnpadConstantTime(t *testing.T) {
+	// This is best-effort: checking the shortest and longest padding should take about the same time.
+	short, long := make([]byte, 16), bytes.Repeat([]byte{16}, 16)
+	short[15] = 1
+
+	timeUnpad := func(block []byte) time.Duration {
+		start := time.Now()
+		for i := 0; i < 100000; i++ {
+			unpadConstantTime(block, 16)
+		}
+
+		return time.Since(start)
+	}
+
+	if shortTime, longTime := timeUnpad(short), timeUnpad(long); shortTime > 4*longTime || longTime > 4*shortTime {
+		t.Fatalf("Unpadding time depends on the padding! %v != %v", shortTime, longTime)
+	}
+
+	if padLen, good := unpadConstantTime(long, 16); padLen != 16 || good != 1 {
+		t.Fatalf("Valid padding was rejected!")
+	} else if _, good := unpadConstantTime(make([]byte, 16), 16); good != 0 {
+		t.Fatalf("Zero padding was accepted!")
+	}
+}
+
+func TestSecureCipher(t *testing.T) {
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	sc, err := NewSecureCipher(&constr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, aad := bytes.Repeat(input, 3)[:37], []byte("associated data")
+
+	ciphertext, err := sc.Seal(plaintext, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cand, err := sc.Open(ciphertext, aad); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", plaintext, cand)
+	}
+
+	// Without external encodings, it's plain AES-GCM.
+	c, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(c)
+	if cand, err := aead.Open(nil, ciphertext[:12], ciphertext[12:], aad); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plaintext, cand) {
+		t.Fatalf("AES-GCM disagrees with result! %x != %x", plaintext, cand)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[20] ^= 1
+	if _, err := sc.Open(tampered, aad); err == nil {
+		t.Fatalf("Tampered ciphertext was opened!")
+	} else if _, err := sc.Open(ciphertext, []byte("other data")); err == nil {
+		t.Fatalf("Ciphertext was opened with tampered associated data!")
+	}
+}
+
+func TestPolyval(t *testing.T) {
+	// From RFC 8452, Appendix A.
+	h, _ := hex.DecodeString("25629347589242761d31f826ba4b757b")
+	x, _ := hex.DecodeString("4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+	real, _ := hex.DecodeString("f7a3b47b846119fae5b7866cf5e5b77e")
+
+	if cand := polyval(newPolyvalElement(h), polyvalElement{}, x).bytes(); !bytes.Equal(real, cand[:]) {
+		t.Fatalf("POLYVAL is wrong! %x != %x", real, cand)
+	}
+}
+
+func TestGCMSIV(t *testing.T) {
+	// From RFC 8452, Appendix C.1.
+	key, _ := hex.DecodeString("01000000000000000000000000000000")
+	nonce, _ := hex.DecodeString("030000000000000000000000")
+
+	constr, _, _ := GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	aead, err := NewGCMSIV(&constr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vectors := []struct{ plaintext, aad, ciphertext string }{
+		{"", "", "dc20e2d83f25705bb49e439eca56de25"},
+		{"0100000000000000", "", "b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+		{"0200000000000000", "01", "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508"},
+	}
+
+	for i, vec := range vectors {
+		plaintext, _ := hex.DecodeString(vec.plaintext)
+		aad, _ := hex.DecodeString(vec.aad)
+		real, _ := hex.DecodeString(vec.ciphertext)
+
+		if cand := aead.Seal(nil, nonce, plaintext, aad); !bytes.Equal(real, cand) {
+			t.Fatalf("Seal disagrees with test vector %v! %x != %x", i, real, cand)
+		}
+
+		if cand, err := aead.Open(nil, nonce, real, aad); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(plaintext, cand) {
+			t.Fatalf("Open disagrees with test vector %v! %x != %x", i, plaintext, cand)
+		}
+
+		real[0] ^= 1
+		if _, err := aead.Open(nil, nonce, real, aad); err == nil {
+			t.Fatalf("Open accepted a forged ciphertext for test vector %v!", i)
+		}
+	}
+}
+
+func TestDaviesMeyer(t *testing.T) {
+	msg := bytes.Repeat(input, 3)[:37]
+
+	// Compute the real digest with the plaintext cipher.
+	padded := append(append([]byte{}, msg...), 0x80)
+	padded = append(padded, make([]byte, 48-len(padded))...)
+	padded[46], padded[47] = byte(len(msg)*8>>8), byte(len(msg)*8)
+
+	real := make([]byte, 16)
+	for base := 0; base < len(padded); base += 16 {
+		c, _ := aes.NewCipher(padded[base : base+16])
+		next := make([]byte, 16)
+		c.Encrypt(next, real)
+
+		for i := range real {
+			real[i] ^= next[i]
+		}
+	}
+
+	dm := NewDaviesMeyer(seed)
+	cand := dm.Sum(msg)
+	if !bytes.Equal(real, cand[:]) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	} else if again := dm.Sum(msg); again != cand {
+		t.Fatalf("Digest isn't stable! %x != %x", cand, again)
+	}
+
+	// Fill the cache, then check that a new block only evicts the oldest one.
+	dm = NewDaviesMeyer(seed)
+	for i := 0; i < daviesMeyerCacheSize; i++ {
+		m := [16]byte{byte(i)}
+		dm.cache[m], dm.order = &Construction{}, append(dm.order, m)
+	}
+	dm.Compress([16]byte{}, [16]byte{0xff})
+
+	if len(dm.cache) != daviesMeyerCacheSize || len(dm.order) != daviesMeyerCacheSize {
+		t.Fatalf("Cache holds %v constructions, not %v!", len(dm.cache), daviesMeyerCacheSize)
+	} else if _, ok := dm.cache[[16]byte{0}]; ok {
+		t.Fatalf("Oldest construction wasn't evicted!")
+	}
+	for i := 1; i < daviesMeyerCacheSize; i++ {
+		if _, ok := dm.cache[[16]byte{byte(i)}]; !ok {
+			t.Fatalf("Construction %v was evicted before the oldest!", i)
+		}
+	}
+	if _, ok := dm.cache[[16]byte{0xff}]; !ok {
+		t.Fatalf("New construction wasn't cached!")
+	}
+}
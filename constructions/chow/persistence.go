@@ -1,7 +1,13 @@
 package chow
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 
 	"github.com/OpenWhiteBox/primitives/table"
 
@@ -9,36 +15,57 @@ import (
 )
 
 const (
-	fullSize = 770048
+	fullSize       = 770048
+	serializedSize = common.MagicSize + fullSize
 
 	maskTableSize = 256 * 16
 	stepTableSize = 256 * 4
 	xorTableSize  = 256 / 2
 )
 
-// Serialize serializes a white-box construction into a byte slice.
+// Serialize serializes a white-box construction into a byte slice: common.ChowMagic, then every table written as its
+// outputs in order of input, and each output byte by byte in order, so the format has no multi-byte fields and is the
+// same on every host.
 func (constr *Construction) Serialize() []byte {
-	out, base := make([]byte, fullSize), 0
+	out := make([]byte, serializedSize)
+	copy(out, common.ChowMagic)
+	constr.serializeTables(out[common.MagicSize:])
+
+	return out
+}
+
+// serializeTables writes the construction's tables into dst, which must be fullSize bytes long, without a header.
+func (constr *Construction) serializeTables(dst []byte) {
+	base := 0
 
 	// Input Mask
-	base += common.SerializeBlockMatrix(out[base:], constr.InputMask, constr.InputXORTables)
+	base += common.SerializeBlockMatrix(dst[base:], constr.InputMask, constr.InputXORTables)
 
 	// First half of round
-	base += serializeStepTables(out[base:], constr.TBoxTyiTable)
-	base += serializeXORTables(out[base:], constr.HighXORTable)
+	base += serializeStepTables(dst[base:], constr.TBoxTyiTable)
+	base += serializeXORTables(dst[base:], constr.HighXORTable)
 
 	// Second half of round
-	base += serializeStepTables(out[base:], constr.MBInverseTable)
-	base += serializeXORTables(out[base:], constr.LowXORTable)
+	base += serializeStepTables(dst[base:], constr.MBInverseTable)
+	base += serializeXORTables(dst[base:], constr.LowXORTable)
 
 	// Output Mask
-	common.SerializeBlockMatrix(out[base:], constr.TBoxOutputMask, constr.OutputXORTables)
-
-	return out
+	common.SerializeBlockMatrix(dst[base:], constr.TBoxOutputMask, constr.OutputXORTables)
 }
 
-// Parse parses a byte array into a white-box construction. It returns an error if the byte array isn't long enough.
+// Parse parses a byte array into a white-box construction. It returns an error if the byte array doesn't start with
+// common.ChowMagic or isn't long enough. A serialization from before the header, which is exactly common.LegacyChowSize
+// bytes long, is parsed too.
 func Parse(in []byte) (constr Construction, err error) {
+	if in, err = common.StripLegacyMagic(in, common.ChowMagic, common.LegacyChowSize); err != nil {
+		return
+	}
+
+	return parseTables(in)
+}
+
+// parseTables parses the construction's tables, as written by serializeTables, out of in.
+func parseTables(in []byte) (constr Construction, err error) {
 	var rest []byte
 
 	constr.InputMask, constr.InputXORTables, rest = common.ParseBlockNibbleMatrix(in)
@@ -53,11 +80,266 @@ func Parse(in []byte) (constr Construction, err error) {
 
 	if rest == nil {
 		err = errors.New("Parsing the key failed!")
+	} else {
+		err = constr.Validate()
 	}
 
 	return
 }
 
+// sections returns the contiguous runs of tables that Serialize writes, in order.
+func (constr *Construction) sections() [][]byte {
+	serializeSlices := func(m [16]table.Block) (out []byte) {
+		for _, slice := range m {
+			out = append(out, table.SerializeBlock(slice)...)
+		}
+		return
+	}
+
+	highXOR, lowXOR := make([]byte, xorTableSize*9*32*3), make([]byte, xorTableSize*9*32*3)
+	serializeXORTables(highXOR, constr.HighXORTable)
+	serializeXORTables(lowXOR, constr.LowXORTable)
+
+	tboxTyi, mbInverse := make([]byte, stepTableSize*9*16), make([]byte, stepTableSize*9*16)
+	serializeStepTables(tboxTyi, constr.TBoxTyiTable)
+	serializeStepTables(mbInverse, constr.MBInverseTable)
+
+	return [][]byte{
+		serializeSlices(constr.InputMask), constr.InputXORTables.Serialize(),
+		tboxTyi, highXOR,
+		mbInverse, lowXOR,
+		serializeSlices(constr.TBoxOutputMask), constr.OutputXORTables.Serialize(),
+	}
+}
+
+// SerializeAligned serializes a white-box construction like Serialize, except that each run of tables of one kind
+// starts at a multiple of pageSize, so that a construction parsed from mmap'd memory by ParseMapped has page-aligned
+// tables. The first page starts with common.ChowAlignedMagic and then the page size, big-endian.
+func (constr *Construction) SerializeAligned(pageSize int) []byte {
+	if pageSize < 4 {
+		panic("Page size must be at least 4 bytes!")
+	}
+	pad := func(out []byte) []byte {
+		return append(out, make([]byte, (pageSize-len(out)%pageSize)%pageSize)...)
+	}
+
+	out := make([]byte, common.MagicSize+4)
+	copy(out, common.ChowAlignedMagic)
+	binary.BigEndian.PutUint32(out[common.MagicSize:], uint32(pageSize))
+
+	for _, section := range constr.sections() {
+		out = append(pad(out), section...)
+	}
+
+	return pad(out)
+}
+
+// ParseMapped parses a byte array serialized by SerializeAligned into a white-box construction. The tables are slices of
+// in rather than copies, so in can be mmap'd memory, and must not be changed while the construction is used. It returns
+// an error if the byte array is malformed.
+func ParseMapped(in []byte) (constr Construction, err error) {
+	header, err := common.StripMagic(in, common.ChowAlignedMagic)
+	if err != nil {
+		return constr, err
+	} else if len(header) < 4 {
+		return constr, errors.New("Parsing the key failed!")
+	}
+	pageSize := int(binary.BigEndian.Uint32(header))
+	if pageSize < 4 || len(in) != alignedSize(pageSize) {
+		// A header written in the wrong byte order would otherwise just look like a bad page size.
+		if swapped := int(binary.LittleEndian.Uint32(header)); swapped >= 4 && len(in) == alignedSize(swapped) {
+			return constr, errors.New("Page size header is little-endian, not big-endian!")
+		}
+
+		return constr, errors.New("Parsing the key failed!")
+	}
+
+	// next returns the next section of in that's size bytes long, starting at a multiple of pageSize.
+	base := common.MagicSize + 4
+	next := func(size int) []byte {
+		base += (pageSize - base%pageSize) % pageSize
+		if base+size > len(in) {
+			err = errors.New("Parsing the key failed!")
+			return nil
+		}
+
+		base += size
+		return in[base-size : base]
+	}
+
+	constr.parseSections(next)
+	if err != nil {
+		return Construction{}, err
+	}
+
+	return constr, constr.Validate()
+}
+
+// sectionSizes is the length of each run of tables returned by sections, in order.
+var sectionSizes = []int{
+	common.SlicesSize, xorTableSize * 32 * 15,
+	stepTableSize * 9 * 16, xorTableSize * 9 * 32 * 3,
+	stepTableSize * 9 * 16, xorTableSize * 9 * 32 * 3,
+	common.SlicesSize, xorTableSize * 32 * 15,
+}
+
+// parseSections parses each run of tables, in the order sections returns them, out of the slice that next returns for
+// its length. The tables are slices of what next returns rather than copies.
+func (constr *Construction) parseSections(next func(size int) []byte) {
+	constr.InputMask, _ = common.ParseBlockSlices(next(sectionSizes[0]))
+	constr.InputXORTables, _ = common.ParseNibbleXORTables(next(sectionSizes[1]))
+
+	constr.TBoxTyiTable, _ = parseStepTables(next(sectionSizes[2]))
+	constr.HighXORTable, _ = parseXORTables(next(sectionSizes[3]))
+
+	constr.MBInverseTable, _ = parseStepTables(next(sectionSizes[4]))
+	constr.LowXORTable, _ = parseXORTables(next(sectionSizes[5]))
+
+	constr.TBoxOutputMask, _ = common.ParseBlockSlices(next(sectionSizes[6]))
+	constr.OutputXORTables, _ = common.ParseNibbleXORTables(next(sectionSizes[7]))
+}
+
+// alignedSize returns the length of the output of SerializeAligned with the given page size.
+func alignedSize(pageSize int) int {
+	roundUp := func(n int) int { return (n + pageSize - 1) / pageSize * pageSize }
+
+	base := common.MagicSize + 4
+	for _, size := range sectionSizes {
+		base = roundUp(base) + size
+	}
+
+	return roundUp(base)
+}
+
+// PeekVariant returns the key size in bits and number of rounds of the AES that a construction serialized by Serialize
+// or SerializeAligned computes, without parsing it. Only AES-128 can be white-boxed with this construction, so the
+// serializations' headers don't say which variant they are; it recognizes the serializations by their magic header and
+// size instead. It returns an error if data isn't either serialization.
+func PeekVariant(data []byte) (keyBits int, rounds int, err error) {
+	if len(data) == serializedSize && string(data[:common.MagicSize]) == common.ChowMagic {
+		return 128, 10, nil
+	} else if header, err := common.StripMagic(data, common.ChowAlignedMagic); err == nil && len(header) >= 4 {
+		if pageSize := int(binary.BigEndian.Uint32(header)); pageSize >= 4 && len(data) == alignedSize(pageSize) {
+			return 128, 10, nil
+		}
+	}
+
+	return 0, 0, errors.New("Unrecognized serialization format!")
+}
+
+// GobEncode serializes the construction for encoding/gob, with Serialize. (Necessary to implement gob.GobEncoder.)
+func (constr *Construction) GobEncode() ([]byte, error) {
+	return constr.Serialize(), nil
+}
+
+// GobDecode parses a construction encoded by GobEncode. (Necessary to implement gob.GobDecoder.)
+func (constr *Construction) GobDecode(in []byte) (err error) {
+	*constr, err = Parse(in)
+	return
+}
+
+// Validate checks the construction's structural invariants: that every table is present, that every parsed table has
+// the right size, and that every XOR table is a bijection of each nibble of its input when the other is fixed. It
+// returns the first violation it finds.
+func (constr *Construction) Validate() error {
+	for pos, t := range constr.InputMask {
+		if err := validateTable(t, maskTableSize, "InputMask", pos); err != nil {
+			return err
+		}
+	}
+	if err := validateNibbleXORTables(constr.InputXORTables, "InputXORTables"); err != nil {
+		return err
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			if err := validateTable(constr.TBoxTyiTable[round][pos], stepTableSize, "TBoxTyiTable", round, pos); err != nil {
+				return err
+			} else if err := validateTable(constr.MBInverseTable[round][pos], stepTableSize, "MBInverseTable", round, pos); err != nil {
+				return err
+			}
+		}
+
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				if err := validateXORTable(constr.HighXORTable[round][pos][gate], "HighXORTable", round, pos, gate); err != nil {
+					return err
+				} else if err := validateXORTable(constr.LowXORTable[round][pos][gate], "LowXORTable", round, pos, gate); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for pos, t := range constr.TBoxOutputMask {
+		if err := validateTable(t, maskTableSize, "TBoxOutputMask", pos); err != nil {
+			return err
+		}
+	}
+
+	return validateNibbleXORTables(constr.OutputXORTables, "OutputXORTables")
+}
+
+func validateNibbleXORTables(nxts common.NibbleXORTables, name string) error {
+	for pos := range nxts {
+		for gate, t := range nxts[pos] {
+			if err := validateXORTable(t, name, pos, gate); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateXORTable returns an error if t fails validateTable or isn't a bijection of each nibble of its input when the
+// other nibble is fixed. Every XOR table is one no matter how its inputs and output are encoded, so a table that isn't
+// has been corrupted.
+func validateXORTable(t table.Nibble, name string, index ...int) error {
+	if err := validateTable(t, xorTableSize, name, index...); err != nil {
+		return err
+	}
+
+	for fixed := 0; fixed < 16; fixed++ {
+		var high, low uint16
+		for x := 0; x < 16; x++ {
+			high |= 1 << t.Get(byte(x<<4|fixed))
+			low |= 1 << t.Get(byte(fixed<<4|x))
+		}
+
+		if high != 0xffff || low != 0xffff {
+			return fmt.Errorf("%v%v isn't a bijection of each nibble!", name, index)
+		}
+	}
+
+	return nil
+}
+
+// validateTable returns an error if t is missing or if it was parsed and isn't size bytes long. Tables that weren't
+// parsed compute their entries on demand, so any size is fine.
+func validateTable(t interface{}, size int, name string, index ...int) error {
+	var n int
+
+	switch t := t.(type) {
+	case nil:
+		return fmt.Errorf("%v%v is missing!", name, index)
+	case table.ParsedNibble:
+		n = len(t)
+	case table.ParsedWord:
+		n = len(t)
+	case table.ParsedBlock:
+		n = len(t)
+	default:
+		return nil
+	}
+
+	if n != size {
+		return fmt.Errorf("%v%v is %v bytes, not %v!", name, index, n, size)
+	}
+
+	return nil
+}
+
 func serializeStepTables(dst []byte, t [9][16]table.Word) int {
 	base := 0
 	for _, round := range t {
@@ -113,3 +395,59 @@ func parseXORTables(in []byte) (out [9][32][3]table.Nibble, rest []byte) {
 
 	return out, in[xorTableSize*9*32*3:]
 }
+
+// Flags for the header of SerializeCompressed's output.
+const (
+	uncompressed byte = iota
+	flateCompressed
+)
+
+// SerializeCompressed serializes a white-box construction like Serialize and compresses it with DEFLATE. It starts with
+// common.ChowCompressedMagic and then a flag byte saying whether the rest is compressed, so that a construction that
+// doesn't compress is written as-is behind it instead of growing. Randomly encoded tables look random, so the saving is
+// usually small.
+func (constr *Construction) SerializeCompressed() []byte {
+	serialized := constr.Serialize()
+
+	buf := bytes.NewBuffer(append([]byte(common.ChowCompressedMagic), flateCompressed))
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	w.Write(serialized)
+	w.Close()
+
+	if buf.Len() > common.MagicSize+len(serialized) {
+		return append(append([]byte(common.ChowCompressedMagic), uncompressed), serialized...)
+	}
+
+	return buf.Bytes()
+}
+
+// ParseCompressed parses a byte array serialized by SerializeCompressed into a white-box construction. It returns an
+// error if the byte array is malformed or doesn't decompress to a serialized construction.
+func ParseCompressed(in []byte) (Construction, error) {
+	in, err := common.StripMagic(in, common.ChowCompressedMagic)
+	if err != nil {
+		return Construction{}, err
+	} else if len(in) < 1 {
+		return Construction{}, errors.New("Parsing the key failed!")
+	}
+
+	switch in[0] {
+	case uncompressed:
+		return Parse(in[1:])
+	case flateCompressed:
+		// Read at most one byte more than a construction, so a malicious input can't make it decompress without bound.
+		r := flate.NewReader(bytes.NewReader(in[1:]))
+		defer r.Close()
+
+		serialized, err := ioutil.ReadAll(io.LimitReader(r, serializedSize+1))
+		if err != nil {
+			return Construction{}, err
+		} else if len(serialized) != serializedSize {
+			return Construction{}, errors.New("Parsing the key failed!")
+		}
+
+		return Parse(serialized)
+	default:
+		return Construction{}, errors.New("Unrecognized compression flag!")
+	}
+}
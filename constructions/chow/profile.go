@@ -0,0 +1,45 @@
+package chow
+
+import (
+	"time"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// GenStats breaks down the time spent generating a construction.
+//
+// Most of the tables in a freshly generated construction are lazy--they're compositions of encodings that are evaluated
+// on every lookup--so generation itself mostly derives encodings. The T-Boxes, Tyi Tables, and XOR tables are only
+// computed when they're materialized.
+type GenStats struct {
+	Encodings time.Duration // Deriving encodings and mixing bijections from the seed.
+	Inversion time.Duration // Inverting the mixing bijections for the MB^(-1) Tables.
+	Tables    time.Duration // Materializing every table, by serializing the construction and parsing it back.
+}
+
+// profile is an Option that tallies time spent in generation in stats.
+func profile(stats *GenStats) Option {
+	return func(rs *source) { rs.stats = stats }
+}
+
+// GenerateKeysProfiled is GenerateEncryptionKeys with random independent masks, except that it also reports where the
+// time was spent. The returned construction has every table materialized, so lookups in it are direct. It returns an
+// error if the materialized construction doesn't parse.
+func GenerateKeysProfiled(key, seed []byte) (*Construction, GenStats, error) {
+	stats := GenStats{}
+
+	start := time.Now()
+	constr, _, _ := GenerateEncryptionKeys(
+		key, seed, common.IndependentMasks{common.RandomMask, common.RandomMask}, profile(&stats),
+	)
+	stats.Encodings = time.Since(start) - stats.Inversion
+
+	start = time.Now()
+	materialized, err := Parse(constr.Serialize())
+	if err != nil {
+		return nil, GenStats{}, err
+	}
+	stats.Tables = time.Since(start)
+
+	return &materialized, stats, nil
+}
@@ -0,0 +1,112 @@
+package chow
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// ShardData is the subset of a construction's serialized tables built by one shard, keyed by table id.
+type ShardData struct {
+	Index, Count int
+	Tables       map[string][]byte
+}
+
+// leafTables lists every table of the construction in the same order as leaves.
+func (constr *Construction) leafTables() (out []interface{}) {
+	blockMatrix := func(masks [16]table.Block, xors common.NibbleXORTables) {
+		for _, t := range masks {
+			out = append(out, t)
+		}
+		for pos := range xors {
+			for _, t := range xors[pos] {
+				out = append(out, t)
+			}
+		}
+	}
+
+	round := func(steps [9][16]table.Word, xors [9][32][3]table.Nibble) {
+		for round := range steps {
+			for _, t := range steps[round] {
+				out = append(out, t)
+			}
+		}
+		for round := range xors {
+			for pos := range xors[round] {
+				for _, t := range xors[round][pos] {
+					out = append(out, t)
+				}
+			}
+		}
+	}
+
+	blockMatrix(constr.InputMask, constr.InputXORTables)
+	round(constr.TBoxTyiTable, constr.HighXORTable)
+	round(constr.MBInverseTable, constr.LowXORTable)
+	blockMatrix(constr.TBoxOutputMask, constr.OutputXORTables)
+
+	return
+}
+
+// GenerateShard builds shard shardIndex of shardCount of the encryption construction that GenerateEncryptionKeys
+// would generate with the same arguments. Deriving the encodings is cheap, so every shard derives all of them, but each
+// shard only materializes every shardCount-th table, which is where the time goes. Merge the shards with MergeShards.
+func GenerateShard(key, seed []byte, opts common.KeyGenerationOpts, shardIndex, shardCount int) (ShardData, error) {
+	if shardCount < 1 || shardIndex < 0 || shardIndex >= shardCount {
+		return ShardData{}, errors.New("Shard index must be between 0 and the shard count!")
+	}
+
+	constr, _, _ := GenerateEncryptionKeys(key, seed, opts)
+	out := ShardData{shardIndex, shardCount, make(map[string][]byte)}
+
+	for i, t := range constr.leafTables() {
+		if i%shardCount != shardIndex {
+			continue
+		}
+
+		switch t := t.(type) {
+		case table.Block:
+			out.Tables[leaves[i].id] = table.SerializeBlock(t)
+		case table.Word:
+			out.Tables[leaves[i].id] = table.SerializeWord(t)
+		case table.Nibble:
+			out.Tables[leaves[i].id] = table.SerializeNibble(t)
+		}
+	}
+
+	return out, nil
+}
+
+// MergeShards assembles a construction from every shard made by GenerateShard. It returns an error if a shard is
+// missing or repeated, or if the shards don't fit together.
+func MergeShards(shards []ShardData) (*Construction, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("No shards to merge!")
+	}
+
+	byIndex := make([]*ShardData, len(shards))
+	for i, shard := range shards {
+		if shard.Count != len(shards) || shard.Index < 0 || shard.Index >= len(shards) || byIndex[shard.Index] != nil {
+			return nil, errors.New("Shards are missing or repeated!")
+		}
+		byIndex[shard.Index] = &shards[i]
+	}
+
+	serialized := make([]byte, 0, fullSize)
+	for i, l := range leaves {
+		data, ok := byIndex[i%len(shards)].Tables[l.id]
+		if !ok || len(data) != l.size {
+			return nil, errors.New("Shards don't fit together!")
+		}
+		serialized = append(serialized, data...)
+	}
+
+	constr, err := parseTables(serialized)
+	if err != nil {
+		return nil, err
+	}
+
+	return &constr, nil
+}
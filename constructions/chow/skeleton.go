@@ -0,0 +1,54 @@
+package chow
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/table"
+)
+
+// Skeleton is the part of a generated construction that only depends on the seed: every encoding and every table
+// except the T-Boxes, which are the only ones that depend on the key. A skeleton can be shared by many keys and
+// combined with each one's T-Boxes by WithKey.
+//
+// A skeleton lives in memory only: it has no Serialize or Parse. Its tables are still unevaluated compositions of
+// encodings waiting for T-Boxes, not byte tables, so there's nothing to write out until WithKey fills them in. To keep
+// one around, keep the seed and options it was generated with, and call EncodingSkeleton again.
+//
+// Everything Rekey says about security applies: constructions built from the same skeleton leak the differences
+// between their keys to anyone who holds more than one of them.
+type Skeleton struct {
+	constr     Construction // With nil T-Boxes.
+	decryption bool
+}
+
+// EncodingSkeleton returns the construction's skeleton: a copy without its T-Boxes, and so without any key material. It
+// returns an error if the construction was parsed from a serialized construction, since its T-Boxes are then baked
+// into the encodings.
+func (constr *Construction) EncodingSkeleton() (*Skeleton, error) {
+	decryption, err := constr.isDecryption()
+	if err != nil {
+		return nil, err
+	}
+
+	stripped, err := constr.withTables(
+		func(int) table.Byte { return nil },
+		func(int, int) table.Word { return nil },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Skeleton{*stripped, decryption}, nil
+}
+
+// WithKey combines the skeleton with key's T-Boxes, returning the construction GenerateEncryptionKeys (or
+// GenerateDecryptionKeys) would return for key and the seed and options the skeleton's construction was generated
+// with.
+func (s *Skeleton) WithKey(key []byte) (*Construction, error) {
+	if len(key) != 16 {
+		return nil, errors.New("Key must be 16 bytes!")
+	}
+
+	skinny, wide := keyTables(key, s.decryption)
+	return s.constr.withTables(skinny, wide)
+}
@@ -0,0 +1,119 @@
+package chow
+
+import (
+	"github.com/OpenWhiteBox/primitives/table"
+)
+
+// squashTableSize is the size in bytes of a SquashTable.
+const squashTableSize = 1 << 16
+
+// SquashTable computes the three-gate XOR cascade of one nibble-wise position of SquashWords in a single lookup. It's
+// indexed by the four encoded nibbles being XORed, and trades 64KB of memory for three lookups.
+type SquashTable []byte
+
+// NewSquashTable flattens the XOR cascade with the given gates into one table.
+func NewSquashTable(gates [3]table.Nibble) SquashTable {
+	out := make(SquashTable, squashTableSize)
+
+	for ab := 0; ab < 256; ab++ {
+		x := gates[0].Get(byte(ab))
+
+		for c := byte(0); c < 16; c++ {
+			y := gates[1].Get(x<<4 | c)
+
+			for d := byte(0); d < 16; d++ {
+				out[ab<<8|int(c)<<4|int(d)] = gates[2].Get(y<<4 | d)
+			}
+		}
+	}
+
+	return out
+}
+
+// Get returns the XOR cascade applied to the nibbles a, b, c, and d.
+func (st SquashTable) Get(a, b, c, d byte) byte {
+	return st[int(a)<<12|int(b)<<8|int(c)<<4|int(d)]
+}
+
+// Flattened is a construction where some of the XOR cascades in the rounds have been replaced with SquashTables. It
+// encrypts and decrypts exactly like the construction it was made from.
+type Flattened struct {
+	*Construction
+
+	High, Low [9][32]SquashTable // [round][nibble-wise position]; nil where the cascade is still used.
+}
+
+// Flatten replaces as many of the construction's High and Low XOR cascades with SquashTables as fit in budget bytes,
+// starting from the first round. Cascades are replaced a column at a time, which costs 1MB.
+func (constr *Construction) Flatten(budget int) (out Flattened) {
+	out.Construction = constr
+
+	for round := 0; round < 9; round++ {
+		for col := 0; col < 32; col += 8 {
+			if budget < 2*8*squashTableSize {
+				return
+			}
+			budget -= 2 * 8 * squashTableSize
+
+			for pos := col; pos < col+8; pos++ {
+				out.High[round][pos] = NewSquashTable(constr.HighXORTable[round][pos])
+				out.Low[round][pos] = NewSquashTable(constr.LowXORTable[round][pos])
+			}
+		}
+	}
+
+	return
+}
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (f Flattened) Encrypt(dst, src []byte) {
+	f.crypt(dst, src, f.shiftRows)
+}
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (f Flattened) Decrypt(dst, src []byte) {
+	f.crypt(dst, src, f.unShiftRows)
+}
+
+// crypt is Construction.crypt, but squashes words with the SquashTables where there are any.
+func (f Flattened) crypt(dst, src []byte, shift func([]byte)) {
+	copy(dst, src[:f.BlockSize()])
+
+	// Remove input encoding.
+	stretched := f.expandBlock(f.InputMask, dst)
+	f.InputXORTables.SquashBlocks(stretched, dst)
+
+	for round := 0; round < 9; round++ {
+		shift(dst)
+
+		// Apply the T-Boxes and Tyi Tables to each column of the state matrix.
+		for pos := 0; pos < 16; pos += 4 {
+			stretched := f.ExpandWord(f.TBoxTyiTable[round][pos:pos+4], dst[pos:pos+4])
+			f.squashWords(f.High[round][2*pos:2*pos+8], f.HighXORTable[round][2*pos:2*pos+8], stretched, dst[pos:pos+4])
+
+			stretched = f.ExpandWord(f.MBInverseTable[round][pos:pos+4], dst[pos:pos+4])
+			f.squashWords(f.Low[round][2*pos:2*pos+8], f.LowXORTable[round][2*pos:2*pos+8], stretched, dst[pos:pos+4])
+		}
+	}
+
+	shift(dst)
+
+	// Apply the final T-Box transformation and add the output encoding.
+	stretched = f.expandBlock(f.TBoxOutputMask, dst)
+	f.OutputXORTables.SquashBlocks(stretched, dst)
+}
+
+// squashWords is SquashWords, but uses the SquashTables for a column where they're present.
+func (f Flattened) squashWords(squash []SquashTable, xorTable [][3]table.Nibble, words [4][4]byte, dst []byte) {
+	if squash[0] == nil {
+		f.SquashWords(xorTable, words, dst)
+		return
+	}
+
+	for pos := 0; pos < 4; pos++ {
+		high := squash[2*pos+0].Get(words[0][pos]>>4, words[1][pos]>>4, words[2][pos]>>4, words[3][pos]>>4)
+		low := squash[2*pos+1].Get(words[0][pos]&0xf, words[1][pos]&0xf, words[2][pos]&0xf, words[3][pos]&0xf)
+
+		dst[pos] = high<<4 | low
+	}
+}
@@ -0,0 +1,184 @@
+package chow
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/OpenWhiteBox/primitives/random"
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// LookupStats tallies the table lookups made by one encryption, by kind of table.
+type LookupStats struct {
+	Mask      int // InputMask and TBoxOutputMask lookups. (The last round's T-Boxes are in TBoxOutputMask.)
+	TBoxTyi   int // T-Box/Tyi Table lookups.
+	MBInverse int // MB^(-1) Table lookups.
+	XOR       int // Nibble-wise XOR Table lookups.
+}
+
+// LookupKind is the kind of table a lookup is made in.
+type LookupKind int
+
+const (
+	MaskLookup      LookupKind = iota // An InputMask or TBoxOutputMask lookup.
+	TBoxTyiLookup                     // A T-Box/Tyi Table lookup.
+	MBInverseLookup                   // A MB^(-1) Table lookup.
+	XORLookup                         // A nibble-wise XOR Table lookup.
+)
+
+// hookedBlock, hookedWord, and hookedNibble call a hook with the index of each lookup in a table, before making it.
+type hookedBlock struct {
+	table.Block
+	kind LookupKind
+	hook func(LookupKind, byte)
+}
+
+func (hb hookedBlock) Get(i byte) [16]byte {
+	hb.hook(hb.kind, i)
+	return hb.Block.Get(i)
+}
+
+type hookedWord struct {
+	table.Word
+	kind LookupKind
+	hook func(LookupKind, byte)
+}
+
+func (hw hookedWord) Get(i byte) [4]byte {
+	hw.hook(hw.kind, i)
+	return hw.Word.Get(i)
+}
+
+type hookedNibble struct {
+	table.Nibble
+	hook func(LookupKind, byte)
+}
+
+func (hn hookedNibble) Get(i byte) byte {
+	hn.hook(XORLookup, i)
+	return hn.Nibble.Get(i)
+}
+
+func hookNibbleXORTables(nxts common.NibbleXORTables, hook func(LookupKind, byte)) (out common.NibbleXORTables) {
+	for pos := range nxts {
+		for gate, t := range nxts[pos] {
+			out[pos][gate] = hookedNibble{t, hook}
+		}
+	}
+
+	return
+}
+
+// WithLookupHook returns a copy of the construction that calls hook with the kind of table and the index of every table
+// lookup it makes, in the order it makes them. It's for instrumenting the construction, like counting its lookups or
+// recording its memory access pattern.
+func (constr *Construction) WithLookupHook(hook func(kind LookupKind, index byte)) (out Construction) {
+	out.InputXORTables = hookNibbleXORTables(constr.InputXORTables, hook)
+	out.OutputXORTables = hookNibbleXORTables(constr.OutputXORTables, hook)
+
+	for pos := 0; pos < 16; pos++ {
+		out.InputMask[pos] = hookedBlock{constr.InputMask[pos], MaskLookup, hook}
+		out.TBoxOutputMask[pos] = hookedBlock{constr.TBoxOutputMask[pos], MaskLookup, hook}
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			out.TBoxTyiTable[round][pos] = hookedWord{constr.TBoxTyiTable[round][pos], TBoxTyiLookup, hook}
+			out.MBInverseTable[round][pos] = hookedWord{constr.MBInverseTable[round][pos], MBInverseLookup, hook}
+		}
+
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				out.HighXORTable[round][pos][gate] = hookedNibble{constr.HighXORTable[round][pos][gate], hook}
+				out.LowXORTable[round][pos][gate] = hookedNibble{constr.LowXORTable[round][pos][gate], hook}
+			}
+		}
+	}
+
+	return
+}
+
+// EncryptWithStats encrypts src like Encrypt, and also counts the table lookups made while doing so.
+func (constr *Construction) EncryptWithStats(src [16]byte) (out [16]byte, stats LookupStats) {
+	counts := [...]*int{
+		MaskLookup:      &stats.Mask,
+		TBoxTyiLookup:   &stats.TBoxTyi,
+		MBInverseLookup: &stats.MBInverse,
+		XORLookup:       &stats.XOR,
+	}
+
+	counted := constr.WithLookupHook(func(kind LookupKind, _ byte) { *counts[kind]++ })
+	counted.Encrypt(out[:], src[:])
+
+	return
+}
+
+// overheadBlocks is the number of blocks BenchmarkExternalOverhead times in each trial, and overheadTrials is the
+// number of trials each way. The fastest trial is kept, to leave out noise from the rest of the machine.
+const (
+	overheadBlocks = 64
+	overheadTrials = 8
+)
+
+// BenchmarkExternalOverhead measures the cost of the external mask stages--InputMask and TBoxOutputMask, with their XOR
+// tables--by timing encryptions with and without them, in milliseconds per block. Without them the output isn't a
+// ciphertext, only the time is. A construction that's been parsed, rather than freshly generated, gives representative
+// numbers, because its tables are materialized.
+func BenchmarkExternalOverhead(c *Construction) (withMs, withoutMs float64) {
+	block := make([]byte, 16)
+	fastest := func(best *float64, crypt func()) {
+		start := time.Now()
+		for i := 0; i < overheadBlocks; i++ {
+			crypt()
+		}
+
+		if ms := float64(time.Since(start)) / float64(time.Millisecond) / overheadBlocks; *best == 0 || ms < *best {
+			*best = ms
+		}
+	}
+
+	for trial := 0; trial < overheadTrials; trial++ {
+		fastest(&withMs, func() { c.Encrypt(block, block) })
+		fastest(&withoutMs, func() {
+			c.rounds(block, c.shiftRows, 9)
+			c.shiftRows(block)
+		})
+	}
+
+	return
+}
+
+// AvalancheScore measures how well the construction diffuses, as a check that it behaves like a good pseudorandom
+// permutation: for each of samples plaintexts, it flips each of the 128 bits in turn and counts the ciphertext bits
+// that change. It returns the average fraction of ciphertext bits that change, which should be close to 0.5. The
+// plaintexts are the same on every call, so the score is deterministic.
+func AvalancheScore(c *Construction, samples int) float64 {
+	if samples < 1 {
+		panic("Need at least one sample!")
+	}
+
+	source := random.NewSource("Chow Avalanche", make([]byte, 16))
+	stream := source.Stream(make([]byte, 16))
+
+	changed := 0
+	plaintext, base, flipped := make([]byte, 16), make([]byte, 16), make([]byte, 16)
+
+	for i := 0; i < samples; i++ {
+		stream.Read(plaintext)
+		c.Encrypt(base, plaintext)
+
+		for bit := uint(0); bit < 128; bit++ {
+			plaintext[bit/8] ^= 1 << (bit % 8)
+			c.Encrypt(flipped, plaintext)
+			plaintext[bit/8] ^= 1 << (bit % 8)
+
+			for j := range flipped {
+				changed += bits.OnesCount8(base[j] ^ flipped[j])
+			}
+		}
+	}
+
+	return float64(changed) / float64(samples*128*128)
+}
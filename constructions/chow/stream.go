@@ -0,0 +1,104 @@
+package chow
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// NewDecryptReader returns a reader that decrypts the ciphertext read from r on the fly. Mode is "cbc", "cfb8", or
+// "ctr". CBC mode is padded with PKCS#7, which is removed at EOF, and needs a decryption construction. CFB8 and CTR
+// (with the default counter policy) aren't padded, and need an encryption construction. The CBC reader implements
+// Resetter.
+func NewDecryptReader(constr *Construction, mode string, iv []byte, r io.Reader) (io.Reader, error) {
+	if len(iv) != constr.BlockSize() {
+		return nil, errors.New("IV length must equal block size!")
+	}
+
+	switch mode {
+	case "cbc":
+		return &cbcReader{constr: constr, mode: cipher.NewCBCDecrypter(constr, iv), r: r}, nil
+	case "cfb8":
+		block := [16]byte{}
+		copy(block[:], iv)
+		return cipher.StreamReader{S: NewCFB8(constr, block, true), R: r}, nil
+	case "ctr":
+		return cipher.StreamReader{S: NewCTR(constr, iv, CounterBE128), R: r}, nil
+	default:
+		return nil, errors.New("Unknown mode!")
+	}
+}
+
+// cbcReader decrypts CBC ciphertext as it's read. The last decrypted block is held back until EOF, since that's the
+// only way to know it's the one with padding on it.
+type cbcReader struct {
+	constr *Construction
+	mode   cipher.BlockMode
+	r      io.Reader
+
+	ciphertext []byte // Read, but not a whole block yet.
+	plaintext  []byte // Decrypted and ready to be returned.
+	held       []byte // The last decrypted block.
+	err        error  // Returned once plaintext is drained.
+}
+
+// Reset starts decrypting a new ciphertext under iv, discarding anything buffered from the last one, including
+// ciphertext that was read ahead. It keeps reading from the same source, so the source should be positioned at the start
+// of the new ciphertext--for example, a bytes.Reader that's been Reset.
+func (cr *cbcReader) Reset(iv []byte) {
+	if len(iv) != cr.constr.BlockSize() {
+		panic("chow: IV length must equal block size")
+	}
+
+	cr.mode = cipher.NewCBCDecrypter(cr.constr, iv)
+	cr.ciphertext, cr.plaintext, cr.held, cr.err = nil, nil, nil, nil
+}
+
+func (cr *cbcReader) Read(p []byte) (int, error) {
+	buf := make([]byte, 4096)
+
+	for len(cr.plaintext) == 0 && cr.err == nil {
+		n, err := cr.r.Read(buf)
+		cr.ciphertext = append(cr.ciphertext, buf[:n]...)
+
+		// Decrypt every whole block, and hold back the last one.
+		if whole := len(cr.ciphertext) / cr.mode.BlockSize() * cr.mode.BlockSize(); whole > 0 {
+			decrypted := make([]byte, whole)
+			cr.mode.CryptBlocks(decrypted, cr.ciphertext[:whole])
+			cr.ciphertext = cr.ciphertext[whole:]
+
+			decrypted = append(cr.held, decrypted...)
+			cr.plaintext = append(cr.plaintext, decrypted[:len(decrypted)-cr.mode.BlockSize()]...)
+			cr.held = decrypted[len(decrypted)-cr.mode.BlockSize():]
+		}
+
+		if err == io.EOF {
+			cr.err = cr.finish()
+		} else if err != nil {
+			cr.err = err
+		}
+	}
+
+	n := copy(p, cr.plaintext)
+	cr.plaintext = cr.plaintext[n:]
+
+	if len(cr.plaintext) == 0 {
+		return n, cr.err
+	}
+	return n, nil
+}
+
+// finish strips the padding off of the held block at EOF, and returns io.EOF if the ciphertext was well-formed.
+func (cr *cbcReader) finish() error {
+	if len(cr.ciphertext) != 0 || cr.held == nil {
+		return errors.New("Ciphertext isn't a positive number of blocks!")
+	}
+
+	last, err := unpad(cr.held, cr.mode.BlockSize())
+	if err != nil {
+		return err
+	}
+	cr.plaintext, cr.held = append(cr.plaintext, last...), nil
+
+	return io.EOF
+}
@@ -0,0 +1,138 @@
+package chow
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/table"
+)
+
+// WalkEncodings calls fn with the input encoding of every table that takes a byte: the InputMask and TBoxOutputMask
+// slices and the T-Box/Tyi and MB^(-1) Tables. Kind is the name of the table's field, and round is 0 for the mask
+// slices.
+//
+// Encodings can only be read off of a generated construction. Tables that were parsed from a serialized construction
+// are skipped.
+func (constr *Construction) WalkEncodings(fn func(kind string, round, position int, enc encoding.Byte)) {
+	block := func(kind string, t table.Block, pos int) {
+		if bt, ok := t.(encoding.BlockTable); ok {
+			fn(kind, 0, pos, bt.In)
+		}
+	}
+
+	word := func(kind string, t table.Word, round, pos int) {
+		if wt, ok := t.(encoding.WordTable); ok {
+			fn(kind, round, pos, wt.In)
+		}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		block("InputMask", constr.InputMask[pos], pos)
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			word("TBoxTyiTable", constr.TBoxTyiTable[round][pos], round, pos)
+			word("MBInverseTable", constr.MBInverseTable[round][pos], round, pos)
+		}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		block("TBoxOutputMask", constr.TBoxOutputMask[pos], pos)
+	}
+}
+
+// encodingSlots is the number of nibble encodings a generated construction puts on the outputs of its tables: 16 per
+// mask slice, 8 per T-Box/Tyi and MB^(-1) Table, and 1 per XOR table except the last gates of OutputXORTables, which
+// produce the construction's output unencoded.
+const encodingSlots = 2*16*32 + 2*9*16*8 + 2*32*15 + 2*9*32*3 - 32
+
+// outputNibbles returns the nibble encodings that are applied last by an output encoding built by generateKeys.
+func outputNibbles(enc interface{}) (out []encoding.Nibble) {
+	switch enc := enc.(type) {
+	case encoding.ConcatenatedByte:
+		return []encoding.Nibble{enc.Left, enc.Right}
+	case encoding.ComposedBytes:
+		return outputNibbles(enc[len(enc)-1])
+	case encoding.ConcatenatedWord:
+		for _, b := range enc {
+			out = append(out, outputNibbles(b)...)
+		}
+	case encoding.ComposedWords:
+		return outputNibbles(enc[len(enc)-1])
+	case encoding.ConcatenatedBlock:
+		for _, b := range enc {
+			out = append(out, outputNibbles(b)...)
+		}
+	}
+
+	return
+}
+
+// DistinctEncodingCount returns how many different nibble encodings the construction puts on the outputs of its
+// tables, for auditing. Every encoding is drawn from the seed under a label made of the round and position it's for,
+// so two slots that share an encoding mean two labels collided. A construction without collisions has one per slot, or
+// 5984.
+//
+// Encodings can only be read off of a generated construction. Tables that were parsed from a serialized construction
+// are skipped.
+func (constr *Construction) DistinctEncodingCount() int {
+	seen := make(map[[16]byte]bool)
+
+	add := func(encs ...encoding.Nibble) {
+		for _, enc := range encs {
+			fingerprint := [16]byte{}
+			for x := byte(0); x < 16; x++ {
+				fingerprint[x] = enc.Encode(x)
+			}
+
+			seen[fingerprint] = true
+		}
+	}
+
+	block := func(t table.Block) {
+		if bt, ok := t.(encoding.BlockTable); ok {
+			add(outputNibbles(bt.Out)...)
+		}
+	}
+
+	word := func(t table.Word) {
+		if wt, ok := t.(encoding.WordTable); ok {
+			add(outputNibbles(wt.Out)...)
+		}
+	}
+
+	nibble := func(t table.Nibble) {
+		if nt, ok := t.(encoding.NibbleTable); ok {
+			add(nt.Out)
+		}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		block(constr.InputMask[pos])
+		block(constr.TBoxOutputMask[pos])
+	}
+
+	for pos := 0; pos < 32; pos++ {
+		for gate := 0; gate < 15; gate++ {
+			nibble(constr.InputXORTables[pos][gate])
+			if gate < 14 {
+				nibble(constr.OutputXORTables[pos][gate])
+			}
+		}
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			word(constr.TBoxTyiTable[round][pos])
+			word(constr.MBInverseTable[round][pos])
+		}
+
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				nibble(constr.HighXORTable[round][pos][gate])
+				nibble(constr.LowXORTable[round][pos][gate])
+			}
+		}
+	}
+
+	return len(seen)
+}
@@ -0,0 +1,264 @@
+package chow
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/rijndael"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
+)
+
+// Wide is Chow et al.'s construction of Rijndael with a 128-bit key and a 128-, 192-, or 256-bit block, for experiments
+// with wider states than AES'. Its rounds are built like a Construction's--T-Box/Tyi Tables, High XOR Tables, MB^(-1)
+// Tables, and Low XOR Tables, under the same internal encodings--but the number of rounds, the number of positions in
+// the state matrix, and the ShiftRows offsets follow the block size.
+//
+// It has no external encodings: its input and output are unencoded, and its last round is one byte table per position.
+// That makes it as weak as a Construction with identity masks, which is fine for experiments but not for deployment.
+type Wide struct {
+	Size int // The block size in bytes: 16, 24, or 32.
+
+	TBoxTyiTable [][]table.Word      // [round][position]
+	HighXORTable [][][3]table.Nibble // [round][nibble-wise position][gate number]
+
+	MBInverseTable [][]table.Word      // [round][position]
+	LowXORTable    [][][3]table.Nibble // [round][nibble-wise position][gate number]
+
+	TBoxTable []table.Byte // [position]
+}
+
+// BlockSize returns the block size of the construction. (Necessary to implement cipher.Block.)
+func (constr Wide) BlockSize() int { return constr.reference().BlockSize() }
+
+// reference returns the reference Rijndael with the construction's block size, for its ShiftRows.
+func (constr Wide) reference() rijndael.Construction { return rijndael.Construction{Size: constr.Size} }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr Wide) Encrypt(dst, src []byte) {
+	ref, size := constr.reference(), constr.BlockSize()
+	copy(dst, src[:size])
+	block := dst[:size]
+
+	var c *Construction // ExpandWord and SquashWords don't use their receiver.
+
+	for round := range constr.TBoxTyiTable {
+		ref.ShiftRows(block)
+
+		for pos := 0; pos < size; pos += 4 {
+			stretched := c.ExpandWord(constr.TBoxTyiTable[round][pos:pos+4], block[pos:pos+4])
+			c.SquashWords(constr.HighXORTable[round][2*pos:2*pos+8], stretched, block[pos:pos+4])
+
+			stretched = c.ExpandWord(constr.MBInverseTable[round][pos:pos+4], block[pos:pos+4])
+			c.SquashWords(constr.LowXORTable[round][2*pos:2*pos+8], stretched, block[pos:pos+4])
+		}
+	}
+
+	ref.ShiftRows(block)
+
+	for pos := range block {
+		block[pos] = constr.TBoxTable[pos].Get(block[pos])
+	}
+}
+
+// landing returns the permutation that takes each position of the state matrix to where ShiftRows moves it.
+func landing(ref rijndael.Construction) func(int) int {
+	size := ref.BlockSize()
+
+	from := make([]byte, size)
+	for pos := range from {
+		from[pos] = byte(pos)
+	}
+	ref.ShiftRows(from)
+
+	to := make([]int, size)
+	for pos, src := range from {
+		to[src] = pos
+	}
+
+	return func(pos int) int { return to[pos] }
+}
+
+// GenerateWideKeys creates a white-boxed version of Rijndael with the given 16-byte key and block size for encryption,
+// with any non-determinism generated by seed. size is 16, 24, or 32 bytes; zero means 16. Options are optional
+// settings, like RejectDegenerateShuffles.
+func GenerateWideKeys(key, seed []byte, size int, options ...Option) (out Wide) {
+	ref := rijndael.Construction{Key: key, Size: size}
+	out.Size = ref.BlockSize()
+
+	rs := newSource("Chow Wide Encryption", seed, options)
+	shift, rounds, aes := landing(ref), ref.Rounds(), saes.Construction{}
+
+	// Apply ShiftRows to every round key but the last, because each is added after the state has been shifted.
+	roundKeys := ref.StretchedKey()
+	for k := 0; k < rounds; k++ {
+		ref.ShiftRows(roundKeys[k])
+	}
+
+	out.TBoxTyiTable, out.MBInverseTable = make([][]table.Word, rounds-1), make([][]table.Word, rounds-1)
+	out.HighXORTable, out.LowXORTable = make([][][3]table.Nibble, rounds-1), make([][][3]table.Nibble, rounds-1)
+
+	for round := 0; round < rounds-1; round++ {
+		out.TBoxTyiTable[round], out.MBInverseTable[round] = make([]table.Word, out.Size), make([]table.Word, out.Size)
+
+		for pos := 0; pos < out.Size; pos++ {
+			mb := common.MixingBijection(rs.Source, 32, round, pos/4)
+
+			// The first round's input is the plaintext, so it has no encoding to decode.
+			var in encoding.Byte = encoding.IdentityByte{}
+			if round > 0 {
+				in = encoding.ComposedBytes{
+					encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round-1, pos)),
+					byteRoundEncoding(rs, round-1, pos, common.Outside, common.NoShift),
+				}
+			}
+
+			out.TBoxTyiTable[round][pos] = encoding.WordTable{
+				in,
+				encoding.ComposedWords{
+					encoding.ConcatenatedWord{
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+0))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+1))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+2))),
+						encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, round, shift(pos/4*4+3))),
+					},
+					encoding.NewWordLinear(mb),
+					wordStepEncoding(rs, round, pos, common.Inside),
+				},
+				TBoxTyiTable{
+					common.TBox{Constr: aes, KeyByte1: roundKeys[round][pos]},
+					common.TyiTable(pos % 4),
+				},
+			}
+
+			out.MBInverseTable[round][pos] = encoding.WordTable{
+				byteRoundEncoding(rs, round, pos, common.Inside, common.NoShift),
+				wordStepEncoding(rs, round, pos, common.Outside),
+				mbInverseTable{rs.invert(mb), uint(pos) % 4},
+			}
+		}
+
+		out.HighXORTable[round] = make([][3]table.Nibble, 2*out.Size)
+		out.LowXORTable[round] = make([][3]table.Nibble, 2*out.Size)
+
+		for pos := 0; pos < 2*out.Size; pos++ {
+			out.HighXORTable[round][pos] = xorGates(rs, round, pos, common.Inside, common.NoShift)
+			out.LowXORTable[round][pos] = xorGates(rs, round, pos, common.Outside, shift)
+		}
+	}
+
+	out.TBoxTable = make([]table.Byte, out.Size)
+	for pos := 0; pos < out.Size; pos++ {
+		out.TBoxTable[pos] = encoding.ByteTable{
+			encoding.ComposedBytes{
+				encoding.NewByteLinear(common.MixingBijection(rs.Source, 8, rounds-2, pos)),
+				byteRoundEncoding(rs, rounds-2, pos, common.Outside, common.NoShift),
+			},
+			encoding.IdentityByte{},
+			common.TBox{aes, roundKeys[rounds-1][pos], roundKeys[rounds][pos]},
+		}
+	}
+
+	return
+}
+
+// Serialize serializes a wide construction into a byte slice: common.ChowWideMagic, a byte with the block size, and
+// then the tables of each round in the same order and layout as a Construction's, followed by the last round's byte
+// tables.
+func (constr *Wide) Serialize() []byte {
+	out := []byte(common.ChowWideMagic)
+	out = append(out, byte(constr.Size))
+
+	serializeWords := func(t [][]table.Word) {
+		for _, round := range t {
+			for _, pos := range round {
+				out = append(out, table.SerializeWord(pos)...)
+			}
+		}
+	}
+
+	serializeXORs := func(t [][][3]table.Nibble) {
+		for _, round := range t {
+			for _, pos := range round {
+				for _, gate := range pos {
+					out = append(out, table.SerializeNibble(gate)...)
+				}
+			}
+		}
+	}
+
+	serializeWords(constr.TBoxTyiTable)
+	serializeXORs(constr.HighXORTable)
+	serializeWords(constr.MBInverseTable)
+	serializeXORs(constr.LowXORTable)
+
+	for _, pos := range constr.TBoxTable {
+		out = append(out, table.SerializeByte(pos)...)
+	}
+
+	return out
+}
+
+// ParseWide parses a byte array into a wide construction. It returns an error if the byte array doesn't start with
+// common.ChowWideMagic, names an unsupported block size, or is the wrong length for it.
+func ParseWide(in []byte) (constr Wide, err error) {
+	if in, err = common.StripMagic(in, common.ChowWideMagic); err != nil {
+		return
+	} else if len(in) < 1 {
+		return Wide{}, errors.New("Parsing the key failed!")
+	}
+
+	constr.Size = int(in[0])
+	if constr.Size != 16 && constr.Size != 24 && constr.Size != 32 {
+		return Wide{}, errors.New("Block size must be 16, 24, or 32 bytes!")
+	}
+
+	rounds := constr.reference().Rounds() - 1
+	words, xors := rounds*constr.Size, rounds*2*constr.Size*3
+
+	if len(in) != 1+2*words*stepTableSize+2*xors*xorTableSize+constr.Size*256 {
+		return Wide{}, errors.New("Parsing the key failed!")
+	}
+
+	rest := in[1:]
+	next := func(size int) (out []byte) {
+		out, rest = rest[:size], rest[size:]
+		return
+	}
+
+	parseWords := func() (out [][]table.Word) {
+		out = make([][]table.Word, rounds)
+		for round := range out {
+			out[round] = make([]table.Word, constr.Size)
+			for pos := range out[round] {
+				out[round][pos] = table.ParsedWord(next(stepTableSize))
+			}
+		}
+		return
+	}
+
+	parseXORs := func() (out [][][3]table.Nibble) {
+		out = make([][][3]table.Nibble, rounds)
+		for round := range out {
+			out[round] = make([][3]table.Nibble, 2*constr.Size)
+			for pos := range out[round] {
+				for gate := range out[round][pos] {
+					out[round][pos][gate] = table.ParsedNibble(next(xorTableSize))
+				}
+			}
+		}
+		return
+	}
+
+	constr.TBoxTyiTable, constr.HighXORTable = parseWords(), parseXORs()
+	constr.MBInverseTable, constr.LowXORTable = parseWords(), parseXORs()
+
+	constr.TBoxTable = make([]table.Byte, constr.Size)
+	for pos := range constr.TBoxTable {
+		constr.TBoxTable[pos] = table.ParsedByte(next(256))
+	}
+
+	return
+}
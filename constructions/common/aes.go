@@ -63,6 +63,22 @@ func (tyi InvTyiTable) Get(i byte) (out [4]byte) {
 	return
 }
 
+// XORBlocks returns the XOR of two blocks.
+func XORBlocks(a, b [16]byte) (out [16]byte) {
+	for i := 0; i < 16; i++ {
+		out[i] = a[i] ^ b[i]
+	}
+
+	return
+}
+
+// XORBlocksInPlace XORs b into dst.
+func XORBlocksInPlace(dst *[16]byte, b [16]byte) {
+	for i := 0; i < 16; i++ {
+		dst[i] ^= b[i]
+	}
+}
+
 func NoShift(i int) int {
 	return i
 }
@@ -24,3 +24,18 @@ func TestTyiTable(t *testing.T) {
 		t.Fatalf("Real disagrees with result! %v != %v", out, cand)
 	}
 }
+
+func TestXORBlocks(t *testing.T) {
+	a := [16]byte{0x00, 0xff, 0x0f, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	b := [16]byte{0xff, 0xff, 0xf0, 0xf0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	out := [16]byte{0xff, 0x00, 0xff, 0x00, 0, 3, 2, 5, 4, 7, 6, 9, 8, 11, 10, 13}
+
+	if cand := XORBlocks(a, b); out != cand {
+		t.Fatalf("Real disagrees with result! %v != %v", out, cand)
+	}
+
+	XORBlocksInPlace(&a, b)
+	if out != a {
+		t.Fatalf("In-place variant disagrees with result! %v != %v", out, a)
+	}
+}
@@ -88,10 +88,7 @@ func (bm BlockMatrix) Get(i byte) (out [16]byte) {
 
 	res := bm.Linear.Mul(matrix.Row(r))
 	copy(out[:], res)
-
-	for i, c := range bm.Constant {
-		out[i] ^= c
-	}
+	XORBlocksInPlace(&out, bm.Constant)
 
 	return
 }
@@ -1,6 +1,8 @@
 package common
 
 import (
+	"errors"
+
 	"github.com/OpenWhiteBox/primitives/table"
 )
 
@@ -45,3 +47,51 @@ func ParseBlockByteMatrix(in []byte) (outM [16]table.Block, outXOR ByteXORTables
 
 	return
 }
+
+// MagicSize is the length of the magic header that every construction's serialization starts with.
+const MagicSize = 8
+
+// The magic header of each construction's serialization, so that a loader can tell them apart without parsing them.
+// Each is "OWB", four letters naming the format, and a version byte that's bumped whenever the format changes.
+const (
+	ChowMagic           = "OWBCHOW\x01"
+	ChowCommittedMagic  = "OWBCMIT\x01"
+	ChowDuplexMagic     = "OWBDPLX\x01"
+	ChowDecoyedMagic    = "OWBDECY\x01"
+	ChowWideMagic       = "OWBWIDE\x01"
+	ChowAlignedMagic    = "OWBCALN\x01"
+	ChowCompressedMagic = "OWBCZIP\x01"
+	ChowFlatMagic       = "OWBCFLT\x01"
+	FullMagic           = "OWBFULL\x01"
+	ToyMagic            = "OWBTOYS\x01"
+	ToyAESMagic         = "OWBTAES\x01"
+	XiaoMagic           = "OWBXIAO\x01"
+)
+
+// StripMagic returns the rest of in after its magic header. It returns an error if in doesn't start with magic.
+func StripMagic(in []byte, magic string) ([]byte, error) {
+	if len(in) < MagicSize || string(in[:MagicSize]) != magic {
+		return nil, errors.New("Wrong magic header!")
+	}
+
+	return in[MagicSize:], nil
+}
+
+// The lengths of the serializations that were written before they had magic headers. The formats are otherwise the
+// same, and every one was a fixed length, so a serialization of exactly this length is one from before the header.
+const (
+	LegacyChowSize = 770048
+	LegacyFullSize = 1091178
+	LegacyToySize  = 22704
+	LegacyXiaoSize = 20994048
+)
+
+// StripLegacyMagic is StripMagic, except that it takes in whole if it's exactly legacySize bytes long, because it was
+// serialized before the format had a magic header.
+func StripLegacyMagic(in []byte, magic string, legacySize int) ([]byte, error) {
+	if len(in) == legacySize {
+		return in, nil
+	}
+
+	return StripMagic(in, magic)
+}
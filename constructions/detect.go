@@ -0,0 +1,52 @@
+// Package constructions holds helpers that apply to every white-box construction in the subpackages.
+package constructions
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// formats maps the magic header of each construction's serialization to its name.
+var formats = map[string]string{
+	common.ChowMagic:           "chow",
+	common.ChowCommittedMagic:  "chow-committed",
+	common.ChowDuplexMagic:     "chow-duplex",
+	common.ChowDecoyedMagic:    "chow-decoyed",
+	common.ChowWideMagic:       "chow-wide",
+	common.ChowAlignedMagic:    "chow-aligned",
+	common.ChowCompressedMagic: "chow-compressed",
+	common.ChowFlatMagic:       "chow-flat",
+	common.FullMagic:           "full",
+	common.ToyMagic:            "toy",
+	common.ToyAESMagic:         "toyaes",
+	common.XiaoMagic:           "xiao",
+}
+
+// legacyFormats maps the length of each serialization from before magic headers were added to its name.
+var legacyFormats = map[int]string{
+	common.LegacyChowSize: "chow",
+	common.LegacyFullSize: "full",
+	common.LegacyToySize:  "toy",
+	common.LegacyXiaoSize: "xiao",
+}
+
+// DetectFormat returns the name of the construction that data is a serialization of--"chow", "chow-committed",
+// "chow-duplex", "chow-decoyed", "chow-wide", "chow-aligned" (from SerializeAligned), "chow-compressed" (from
+// SerializeCompressed), "chow-flat" (from ExportFlat), "full", "toy", "toyaes", or "xiao"--so a loader can call the
+// right package's Parse. It only looks at the magic header at the start of data, or at its length if it's one of the
+// fixed-length serializations from before they had headers, so the matching Parse can still fail.
+func DetectFormat(data []byte) (string, error) {
+	if name, ok := legacyFormats[len(data)]; ok {
+		return name, nil
+	} else if len(data) < common.MagicSize {
+		return "", errors.New("Unrecognized serialization format!")
+	}
+
+	name, ok := formats[string(data[:common.MagicSize])]
+	if !ok {
+		return "", errors.New("Unrecognized serialization format!")
+	}
+
+	return name, nil
+}
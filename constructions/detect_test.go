@@ -0,0 +1,72 @@
+package constructions
+
+import (
+	"testing"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/full"
+	"github.com/OpenWhiteBox/AES/constructions/toy"
+	"github.com/OpenWhiteBox/AES/constructions/toyaes"
+	"github.com/OpenWhiteBox/AES/constructions/xiao"
+)
+
+var (
+	key  = []byte{72, 101, 108, 108, 111, 32, 87, 111, 114, 108, 100, 33, 33, 33, 33, 33}
+	seed = []byte{38, 41, 142, 156, 29, 181, 23, 194, 21, 250, 223, 183, 210, 168, 214, 145}
+)
+
+func TestDetectFormat(t *testing.T) {
+	chowConstr, _, _ := chow.GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+	committed := chow.Commit(chowConstr, key, seed)
+	duplex, _, _ := chow.GenerateDuplex(key, seed)
+	decoyed := chow.WithDecoys(chowConstr, seed, 2)
+	wide := chow.GenerateWideKeys(key, seed, 32)
+	fullConstr, _, _ := full.GenerateKeys(key, seed)
+	toyConstr, _, _ := toy.GenerateKeys(key, seed)
+	toyAESConstr := toyaes.GenerateKeys(key[:2], seed)
+	xiaoConstr, _, _ := xiao.GenerateEncryptionKeys(key, seed, common.SameMasks(common.IdentityMask))
+
+	cases := []struct {
+		data []byte
+		name string
+	}{
+		{chowConstr.Serialize(), "chow"},
+		{committed.Serialize(), "chow-committed"},
+		{duplex.Serialize(), "chow-duplex"},
+		{decoyed.Serialize(), "chow-decoyed"},
+		{wide.Serialize(), "chow-wide"},
+		{chowConstr.SerializeAligned(4096), "chow-aligned"},
+		{chowConstr.SerializeCompressed(), "chow-compressed"},
+		{chowConstr.ExportFlat(), "chow-flat"},
+		{fullConstr.Serialize(), "full"},
+		{toyConstr.Serialize(), "toy"},
+		{toyAESConstr.Serialize(), "toyaes"},
+		{xiaoConstr.Serialize(), "xiao"},
+
+		// Serializations from before magic headers were added.
+		{chowConstr.Serialize()[common.MagicSize:], "chow"},
+		{fullConstr.Serialize()[common.MagicSize:], "full"},
+		{toyConstr.Serialize()[common.MagicSize:], "toy"},
+		{xiaoConstr.Serialize()[common.MagicSize:], "xiao"},
+	}
+
+	for _, cse := range cases {
+		if name, err := DetectFormat(cse.data); err != nil {
+			t.Fatal(err)
+		} else if name != cse.name {
+			t.Fatalf("DetectFormat returned %v, not %v!", name, cse.name)
+		}
+	}
+
+	if _, err := DetectFormat([]byte("garbage")); err == nil {
+		t.Fatalf("DetectFormat recognized garbage!")
+	}
+
+	// A later version of a format isn't recognized as this one.
+	future := chowConstr.Serialize()
+	future[common.MagicSize-1]++
+	if _, err := DetectFormat(future); err == nil {
+		t.Fatalf("DetectFormat recognized an unknown version!")
+	}
+}
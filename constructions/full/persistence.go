@@ -2,11 +2,13 @@ package full
 
 import (
 	"errors"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
 )
 
-// Serialize serializes a white-box construction into a byte slice.
+// Serialize serializes a white-box construction into a byte slice, after common.FullMagic.
 func (constr *Construction) Serialize() []byte {
-	out := make([]byte, 0)
+	out := []byte(common.FullMagic)
 
 	for _, round := range constr {
 		round.serialize(&out)
@@ -15,9 +17,13 @@ func (constr *Construction) Serialize() []byte {
 	return out
 }
 
-// Parse parses a byte array into a white-box construction. It returns an error if the byte slice isn't long enough.
+// Parse parses a byte array into a white-box construction. It returns an error if the byte slice doesn't start with
+// common.FullMagic or isn't long enough. A serialization from before the header, which is exactly common.LegacyFullSize
+// bytes long, is parsed too.
 func Parse(in []byte) (constr Construction, err error) {
-	if len(in) != 1091178 {
+	if in, err = common.StripLegacyMagic(in, common.FullMagic, common.LegacyFullSize); err != nil {
+		return
+	} else if len(in) != 1091178 {
 		return constr, errors.New("key is the wrong size")
 	}
 
@@ -0,0 +1,150 @@
+package luo
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+	"github.com/OpenWhiteBox/primitives/random"
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
+)
+
+// finalTBox is the last round's T-Box, placed in its byte of the output word because the last round doesn't have
+// MixColumns.
+type finalTBox struct {
+	TBox     table.Byte
+	Position int
+}
+
+func (ft finalTBox) Get(i byte) (out [4]byte) {
+	out[ft.Position] = ft.TBox.Get(i)
+	return
+}
+
+// columnTable places the output of a Word table in its column of an otherwise empty block. It implements table.Block.
+type columnTable struct {
+	Word   table.Word
+	Column int
+}
+
+func (ct columnTable) Get(i byte) (out [16]byte) {
+	res := ct.Word.Get(i)
+	copy(out[4*ct.Column:], res[:])
+
+	return
+}
+
+// blockDiagonal returns the 128x128 matrix with an 8-bit mixing bijection for each byte of the block on its diagonal.
+func blockDiagonal(rs *random.Source, round int) matrix.Matrix {
+	out := matrix.GenerateEmpty(128, 128)
+
+	for row := 0; row < 128; row += 8 {
+		m := common.MixingBijection(rs, 8, round, row/8)
+
+		for subRow := 0; subRow < 8; subRow++ {
+			copy(out[row+subRow][row/8:], m[subRow])
+		}
+	}
+
+	return out
+}
+
+// shiftRows returns ShiftRows as a 128x128 permutation matrix.
+func shiftRows() matrix.Matrix {
+	out, id := matrix.GenerateEmpty(128, 128), matrix.GenerateIdentity(8)
+
+	for pos := 0; pos < 16; pos++ {
+		for subRow := 0; subRow < 8; subRow++ {
+			copy(out[8*pos+subRow][common.UnShiftRows(pos):], id[subRow])
+		}
+	}
+
+	return out
+}
+
+// roundConstant returns the random constant that the tables of the given round add to their output.
+func roundConstant(rs *random.Source, round int) (out [16]byte) {
+	label := make([]byte, 16)
+	label[0], label[1] = 'A', byte(round)
+
+	rs.Stream(label).Read(out[:])
+
+	return
+}
+
+// GenerateKeys creates a white-boxed version of the AES key `key` for encryption, with any non-determinism generated by
+// `seed`.
+func GenerateKeys(key, seed []byte, opts common.KeyGenerationOpts) (out Construction, inputMask, outputMask matrix.Matrix) {
+	rs := random.NewSource("Luo Encryption", seed)
+
+	constr := saes.Construction{key}
+	roundKeys := constr.StretchedKey()
+
+	// Apply ShiftRows to round keys 0 to 9.
+	for k := 0; k < 10; k++ {
+		constr.ShiftRows(roundKeys[k])
+	}
+
+	common.GenerateMasks(&rs, opts, &inputMask, &outputMask)
+
+	// Generate the dense mixing bijection and constant on each round's output. The last round has no constant.
+	mixing, constants := [10]matrix.Matrix{}, [10][16]byte{}
+	for round := 0; round < 10; round++ {
+		mixing[round] = common.MixingBijection(&rs, 128, round, 0)
+		if round < 9 {
+			constants[round] = roundConstant(&rs, round)
+		}
+	}
+
+	// Generate the barriers between rounds, which decode the last round's output, compute ShiftRows, and re-encode. The
+	// constant on the last round's output comes through the barrier as the constant on this round's input.
+	sr := shiftRows()
+	inputConstants := [10][16]byte{}
+
+	out.Barrier[0] = blockDiagonal(&rs, 0).Compose(sr).Compose(inputMask)
+	for round := 1; round < 10; round++ {
+		mixingInv, _ := mixing[round-1].Invert()
+		out.Barrier[round] = blockDiagonal(&rs, round).Compose(sr).Compose(mixingInv)
+
+		copy(inputConstants[round][:], out.Barrier[round].Mul(matrix.Row(constants[round-1][:])))
+	}
+
+	mixingInv, _ := mixing[9].Invert()
+	out.FinalMask = outputMask.Compose(mixingInv)
+
+	// Generate the round tables. Each byte of the state comes in under an affine encoding and the tables' outputs XOR
+	// together into the state under the round's dense mixing bijection and constant.
+	for round := 0; round < 10; round++ {
+		mixed := encoding.NewBlockLinear(mixing[round])
+
+		for pos := 0; pos < 16; pos++ {
+			var hidden table.Word
+			if round < 9 {
+				hidden = table.ComposedToWord{
+					common.TBox{Constr: constr, KeyByte1: roundKeys[round][pos]},
+					common.TyiTable(pos % 4),
+				}
+			} else {
+				hidden = finalTBox{common.TBox{constr, roundKeys[9][pos], roundKeys[10][pos]}, pos % 4}
+			}
+
+			// Only the first table adds the constant, so that it survives the XOR.
+			var output encoding.Block = mixed
+			if pos == 0 {
+				output = encoding.ComposedBlocks{mixed, encoding.BlockAdditive(constants[round])}
+			}
+
+			out.TBoxMixCol[round][pos] = encoding.BlockTable{
+				encoding.ComposedBytes{
+					encoding.NewByteLinear(common.MixingBijection(&rs, 8, round, pos)),
+					encoding.ByteAdditive(inputConstants[round][pos]),
+				},
+				output,
+				columnTable{hidden, pos / 4},
+			}
+		}
+	}
+
+	return out, inputMask, outputMask
+}
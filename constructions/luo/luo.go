@@ -0,0 +1,63 @@
+// Package luo implements a white-box AES construction after Luo, Lai and You, which hardens the design of Xiao-Lai
+// against the BGE attack by spreading each round across larger affine transformations.
+//
+// The interface here is very similar to the one presented in the constructions/xiao package. Xiao-Lai's construction
+// decodes its state in 16-bit pieces, so each table mixes two bytes at a time behind a small encoding. This
+// construction instead spreads every round across the whole block: the round tables take one encoded byte each,
+// compute the T-Box and its share of MixColumns, and put their column of output under a dense 128x128 mixing bijection,
+// so that XORing the tables' outputs together gives the whole state under it. The barrier before the next round is one
+// dense 128x128 matrix that undoes that bijection, computes ShiftRows, and re-encodes each byte under an 8-bit mixing
+// bijection. Each round's state also carries a random constant that's only ever added in by the tables of the round
+// before and removed by the tables of the round after, so it isn't stored anywhere in the clear. The first round's is
+// zero, because its input is the masked plaintext, and so is the one after the last round, which FinalMask would have
+// to remove.
+//
+// "A New Attempt of White-box AES Implementation" by Rui Luo, Xuejia Lai and Rong You, which builds on
+// "A Secure Implementation of White-Box AES" by Yaying Xiao and Xuejia Lai,
+// http://ieeexplore.ieee.org/xpl/login.jsp?arnumber=5404239
+package luo
+
+import (
+	"github.com/OpenWhiteBox/primitives/matrix"
+	"github.com/OpenWhiteBox/primitives/table"
+)
+
+type Construction struct {
+	Barrier    [10]matrix.Matrix
+	TBoxMixCol [10][16]table.Block
+
+	FinalMask matrix.Matrix
+}
+
+// BlockSize returns the block size of AES.
+func (constr Construction) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr Construction) Encrypt(dst, src []byte) {
+	copy(dst, src[:constr.BlockSize()])
+
+	for round := 0; round < 10; round++ {
+		// ShiftRows and re-encoding step.
+		copy(dst, constr.Barrier[round].Mul(matrix.Row(dst[:16])))
+
+		// Apply T-Boxes and MixColumns.
+		constr.SquashBlock(constr.TBoxMixCol[round], dst)
+	}
+
+	copy(dst, constr.FinalMask.Mul(matrix.Row(dst[:16])))
+}
+
+// SquashBlock pushes each byte of the state through its table and XORs the outputs back into the state.
+func (constr *Construction) SquashBlock(tmc [16]table.Block, block []byte) {
+	out := [16]byte{}
+
+	for pos := 0; pos < 16; pos++ {
+		res := tmc[pos].Get(block[pos])
+
+		for j := 0; j < 16; j++ {
+			out[j] ^= res[j]
+		}
+	}
+
+	copy(block, out[:])
+}
@@ -0,0 +1,68 @@
+package luo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
+
+	test_vectors "github.com/OpenWhiteBox/AES/constructions/test"
+)
+
+func TestShiftRows(t *testing.T) {
+	in := []byte{99, 83, 224, 140, 9, 96, 225, 4, 205, 112, 183, 81, 186, 202, 208, 231}
+
+	real := append([]byte{}, in...)
+	(&saes.Construction{}).ShiftRows(real)
+
+	if cand := shiftRows().Mul(matrix.Row(in)); !bytes.Equal(real, cand) {
+		t.Fatalf("ShiftRows matrix disagrees with AES! %x != %x", real, cand)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	for n, vec := range test_vectors.GetAESVectors(testing.Short()) {
+		constr, inputMask, outputMask := GenerateKeys(
+			vec.Key, vec.Key, common.IndependentMasks{common.RandomMask, common.RandomMask},
+		)
+
+		inputInv, _ := inputMask.Invert()
+		outputInv, _ := outputMask.Invert()
+
+		in, out := make([]byte, 16), make([]byte, 16)
+
+		copy(in, inputInv.Mul(matrix.Row(vec.In))) // Apply input encoding.
+
+		constr.Encrypt(out, in)
+
+		copy(out, outputInv.Mul(matrix.Row(out))) // Remove output encoding.
+
+		if !bytes.Equal(vec.Out, out) {
+			t.Fatalf("Real disagrees with result in test vector %v! %x != %x", n, vec.Out, out)
+		}
+	}
+}
+
+func TestBarrierIsDense(t *testing.T) {
+	key := []byte{72, 101, 108, 108, 111, 32, 87, 111, 114, 108, 100, 33, 33, 33, 33, 33}
+	constr, _, _ := GenerateKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	// After the first round, every byte of a barrier's output should depend on every byte of its input.
+	for round := 1; round < 10; round++ {
+		for row := 0; row < 16; row++ {
+			for col := 0; col < 16; col++ {
+				zero := true
+				for subRow := 0; subRow < 8; subRow++ {
+					zero = zero && constr.Barrier[round][8*row+subRow][col] == 0
+				}
+
+				if zero {
+					t.Fatalf("Byte %v of barrier %v's output doesn't depend on byte %v of its input!", row, round, col)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package rijndael implements a reference copy of Rijndael with a 128-bit key and a 128-, 192-, or 256-bit block. With
+// a 128-bit block it's AES-128. It's meant for experiments with wider states than AES'.
+package rijndael
+
+import (
+	"github.com/OpenWhiteBox/AES/constructions/saes"
+)
+
+type Construction struct {
+	// A 16-byte key.
+	Key []byte
+
+	// The block size in bytes: 16, 24, or 32. Zero means 16.
+	Size int
+}
+
+// BlockSize returns the block size of the cipher. (Necessary to implement cipher.Block.)
+func (constr Construction) BlockSize() int {
+	switch constr.Size {
+	case 0:
+		return 16
+	case 16, 24, 32:
+		return constr.Size
+	default:
+		panic("Block size must be 16, 24, or 32 bytes!")
+	}
+}
+
+// columns returns the number of columns in the state matrix.
+func (constr Construction) columns() int { return constr.BlockSize() / 4 }
+
+// Rounds returns the number of rounds of the cipher.
+func (constr Construction) Rounds() int {
+	if nb := constr.columns(); nb > 4 {
+		return nb + 6
+	}
+
+	return 10
+}
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr Construction) Encrypt(dst, src []byte) {
+	roundKeys := constr.StretchedKey()
+	copy(dst, src[:constr.BlockSize()])
+	block := dst[:constr.BlockSize()]
+
+	constr.AddRoundKey(roundKeys[0], block)
+	for i := 1; i < constr.Rounds(); i++ {
+		constr.SubBytes(block)
+		constr.ShiftRows(block)
+		constr.MixColumns(block)
+		constr.AddRoundKey(roundKeys[i], block)
+	}
+
+	constr.SubBytes(block)
+	constr.ShiftRows(block)
+	constr.AddRoundKey(roundKeys[constr.Rounds()], block)
+}
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr Construction) Decrypt(dst, src []byte) {
+	roundKeys := constr.StretchedKey()
+	copy(dst, src[:constr.BlockSize()])
+	block := dst[:constr.BlockSize()]
+
+	constr.AddRoundKey(roundKeys[constr.Rounds()], block)
+	constr.UnShiftRows(block)
+	constr.UnSubBytes(block)
+
+	for i := constr.Rounds() - 1; i >= 1; i-- {
+		constr.AddRoundKey(roundKeys[i], block)
+		constr.UnMixColumns(block)
+		constr.UnShiftRows(block)
+		constr.UnSubBytes(block)
+	}
+
+	constr.AddRoundKey(roundKeys[0], block)
+}
+
+// StretchedKey implements Rijndael's key schedule. It returns the Rounds()+1 round keys derived from the master key,
+// each a block long.
+func (constr Construction) StretchedKey() [][]byte {
+	aes := saes.Construction{}
+	nb, nr := constr.columns(), constr.Rounds()
+
+	stretched := make([]uint32, nb*(nr+1))
+	for i := 0; i < 4; i++ { // First key-length of stretched is the raw key.
+		stretched[i] = uint32(constr.Key[4*i])<<24 | uint32(constr.Key[4*i+1])<<16 |
+			uint32(constr.Key[4*i+2])<<8 | uint32(constr.Key[4*i+3])
+	}
+
+	rcon := byte(0x01)
+	for i := 4; i < len(stretched); i++ {
+		temp := stretched[i-1]
+
+		if i%4 == 0 {
+			temp = aes.SubWord(temp<<8|temp>>24) ^ uint32(rcon)<<24
+			rcon = xtime(rcon)
+		}
+
+		stretched[i] = stretched[i-4] ^ temp
+	}
+
+	split := make([][]byte, nr+1)
+	for j := range split {
+		split[j] = make([]byte, 4*nb)
+
+		for k := 0; k < nb; k++ {
+			word := stretched[nb*j+k]
+			split[j][4*k], split[j][4*k+1], split[j][4*k+2], split[j][4*k+3] =
+				byte(word>>24), byte(word>>16), byte(word>>8), byte(word)
+		}
+	}
+
+	return split
+}
+
+// xtime multiplies x by 0x02 in GF(2^8).
+func xtime(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+
+	return x << 1
+}
+
+// AddRoundKey XORs roundKey into block.
+func (constr Construction) AddRoundKey(roundKey, block []byte) {
+	for i := range block {
+		block[i] ^= roundKey[i]
+	}
+}
+
+// SubBytes rewrites each byte of block with its image under the S-box.
+func (constr Construction) SubBytes(block []byte) {
+	aes := saes.Construction{}
+	for i := range block {
+		block[i] = aes.SubByte(block[i])
+	}
+}
+
+// UnSubBytes is the inverse of SubBytes.
+func (constr Construction) UnSubBytes(block []byte) {
+	aes := saes.Construction{}
+	for i := range block {
+		block[i] = aes.UnSubByte(block[i])
+	}
+}
+
+// shifts returns how far each row of the state matrix is rotated left by ShiftRows.
+func (constr Construction) shifts() [4]int {
+	if constr.columns() == 8 {
+		return [4]int{0, 1, 3, 4}
+	}
+
+	return [4]int{0, 1, 2, 3}
+}
+
+// ShiftRows rotates each row of the state matrix left by its offset.
+func (constr Construction) ShiftRows(block []byte) {
+	nb, shifts := constr.columns(), constr.shifts()
+	out := make([]byte, 4*nb)
+
+	for col := 0; col < nb; col++ {
+		for row := 0; row < 4; row++ {
+			out[4*col+row] = block[4*((col+shifts[row])%nb)+row]
+		}
+	}
+
+	copy(block, out)
+}
+
+// UnShiftRows is the inverse of ShiftRows.
+func (constr Construction) UnShiftRows(block []byte) {
+	nb, shifts := constr.columns(), constr.shifts()
+	out := make([]byte, 4*nb)
+
+	for col := 0; col < nb; col++ {
+		for row := 0; row < 4; row++ {
+			out[4*((col+shifts[row])%nb)+row] = block[4*col+row]
+		}
+	}
+
+	copy(block, out)
+}
+
+// MixColumns applies AES' MixColumn to each column of the state matrix.
+func (constr Construction) MixColumns(block []byte) {
+	aes := saes.Construction{}
+	for i := 0; i < len(block); i += 4 {
+		aes.MixColumn(block[i : i+4])
+	}
+}
+
+// UnMixColumns is the inverse of MixColumns.
+func (constr Construction) UnMixColumns(block []byte) {
+	aes := saes.Construction{}
+	for i := 0; i < len(block); i += 4 {
+		aes.UnMixColumn(block[i : i+4])
+	}
+}
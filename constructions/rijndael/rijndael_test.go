@@ -0,0 +1,78 @@
+package rijndael
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	key   = []byte{72, 101, 108, 108, 111, 32, 87, 111, 114, 108, 100, 33, 33, 33, 33, 33}
+	input = []byte{
+		99, 83, 224, 140, 9, 96, 225, 4, 205, 112, 183, 81, 186, 202, 208, 231,
+		38, 41, 142, 156, 29, 181, 23, 194, 21, 250, 223, 183, 210, 168, 214, 145,
+	}
+)
+
+func TestAES(t *testing.T) {
+	cand, real := make([]byte, 16), make([]byte, 16)
+
+	Construction{Key: key}.Encrypt(cand, input)
+
+	c, _ := aes.NewCipher(key)
+	c.Encrypt(real, input)
+
+	if !bytes.Equal(real, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", real, cand)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		constr := Construction{Key: key, Size: size}
+		cand := make([]byte, size)
+
+		constr.Encrypt(cand, input)
+		if bytes.Equal(input[:size], cand) {
+			t.Fatalf("Size %v: encryption is the identity!", size)
+		}
+
+		constr.Decrypt(cand, cand)
+		if !bytes.Equal(input[:size], cand) {
+			t.Fatalf("Size %v: decryption disagrees with plaintext! %x != %x", size, input[:size], cand)
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	// Brian Gladman's test values for Rijndael with a 128-bit key, from the Rijndael submission's block/key length
+	// combinations. Each plaintext is a prefix of the same string.
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	in, _ := hex.DecodeString("3243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c8")
+
+	cases := []struct {
+		size int
+		out  string
+	}{
+		{16, "3925841d02dc09fbdc118597196a0b32"},
+		{24, "b24d275489e82bb8f7375e0d5fcdb1f481757c538b65148a"},
+		{32, "7d15479076b69a46ffb3b3beae97ad8313f622f67fedb487de9f06b9ed9c8f19"},
+	}
+
+	for _, cse := range cases {
+		real, _ := hex.DecodeString(cse.out)
+		cand := make([]byte, cse.size)
+
+		constr := Construction{Key: key, Size: cse.size}
+		constr.Encrypt(cand, in)
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Size %v: real disagrees with result! %x != %x", cse.size, real, cand)
+		}
+
+		constr.Decrypt(cand, real)
+		if !bytes.Equal(in[:cse.size], cand) {
+			t.Fatalf("Size %v: decryption disagrees with plaintext! %x != %x", cse.size, in[:cse.size], cand)
+		}
+	}
+}
@@ -5,13 +5,15 @@ import (
 
 	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
 )
 
 const fullSize = 11 * (128 + 1) * 16
 
-// Serialize serializes a white-box construction into a byte slice.
+// Serialize serializes a white-box construction into a byte slice, after common.ToyMagic.
 func (constr *Construction) Serialize() []byte {
-	out := make([]byte, 0)
+	out := []byte(common.ToyMagic)
 
 	for _, round := range constr {
 		for _, row := range round.Forwards {
@@ -23,9 +25,13 @@ func (constr *Construction) Serialize() []byte {
 	return out
 }
 
-// Parse parses a byte array into a white-box construction. It returns an error if the byte slice isn't long enough.
+// Parse parses a byte array into a white-box construction. It returns an error if the byte slice doesn't start with
+// common.ToyMagic or isn't long enough. A serialization from before the header, which is exactly common.LegacyToySize
+// bytes long, is parsed too.
 func Parse(in []byte) (constr Construction, err error) {
-	if len(in) != fullSize {
+	if in, err = common.StripLegacyMagic(in, common.ToyMagic, common.LegacyToySize); err != nil {
+		return
+	} else if len(in) != fullSize {
 		err = errors.New("Parsing the key failed.")
 		return
 	}
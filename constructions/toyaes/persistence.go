@@ -0,0 +1,74 @@
+package toyaes
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+const (
+	nibbleInputSize = 16
+	xorTableSize    = 256 / 2
+
+	fullSize = 4*nibbleInputSize + 4*xorTableSize + 4*nibbleInputSize
+)
+
+// nibbleInputTable is a parsed table that's only defined on nibbles: the T-Box/MixColumn tables and T-Boxes only ever
+// see one nibble of the state, so only their first 16 outputs are serialized. It implements table.Byte and
+// table.Nibble.
+type nibbleInputTable []byte
+
+func (nit nibbleInputTable) Get(i byte) byte { return nit[i] }
+
+// serializeNibbleInput returns the outputs of t on every nibble, in order of input.
+func serializeNibbleInput(t table.Byte) []byte {
+	out := make([]byte, nibbleInputSize)
+	for i := range out {
+		out[i] = t.Get(byte(i))
+	}
+
+	return out
+}
+
+// Serialize serializes a white-box construction into a byte slice: common.ToyAESMagic, then the T-Box/MixColumn tables,
+// the XOR tables, and the T-Boxes, each in order of position. The T-Box/MixColumn tables and T-Boxes are only written for
+// nibble inputs, 16 bytes each.
+func (constr *Construction) Serialize() []byte {
+	out := []byte(common.ToyAESMagic)
+
+	for _, t := range constr.TBoxMixColumn {
+		out = append(out, serializeNibbleInput(t)...)
+	}
+	for _, t := range constr.XORTable {
+		out = append(out, table.SerializeNibble(t)...)
+	}
+	for _, t := range constr.TBox {
+		out = append(out, serializeNibbleInput(t)...)
+	}
+
+	return out
+}
+
+// Parse parses a byte array into a white-box construction. It returns an error if the byte array doesn't start with
+// common.ToyAESMagic or is the wrong size.
+func Parse(in []byte) (constr Construction, err error) {
+	if in, err = common.StripMagic(in, common.ToyAESMagic); err != nil {
+		return
+	} else if len(in) != fullSize {
+		return constr, errors.New("Parsing the key failed!")
+	}
+
+	for pos := range constr.TBoxMixColumn {
+		constr.TBoxMixColumn[pos], in = nibbleInputTable(in[:nibbleInputSize]), in[nibbleInputSize:]
+	}
+	for pos := range constr.XORTable {
+		constr.XORTable[pos], in = table.ParsedNibble(in[:xorTableSize]), in[xorTableSize:]
+	}
+	for pos := range constr.TBox {
+		constr.TBox[pos], in = nibbleInputTable(in[:nibbleInputSize]), in[nibbleInputSize:]
+	}
+
+	return
+}
@@ -0,0 +1,139 @@
+// Package toyaes implements Mini-AES, a scaled-down AES over GF(2^4) for teaching, and a Chow-style white-box of it.
+//
+// Mini-AES has a 16-bit block, seen as a 2x2 matrix of nibbles, and a 16-bit key. It has two rounds of a 4-bit S-box,
+// a ShiftRow that swaps the nibbles in the second row, and (in the first round only) a 2x2 MixColumn. The white-box is
+// small enough that every one of its tables can be printed and checked by hand.
+//
+// "Mini Advanced Encryption Standard (Mini-AES): A Testbed for Cryptanalysis Students" by Raphael Chung-Wei Phan,
+// https://doi.org/10.1080/0161-110291890885
+package toyaes
+
+// sbox is Mini-AES' NibbleSub S-box, and invSbox is its inverse.
+var (
+	sbox    = [16]byte{0xe, 0x4, 0xd, 0x1, 0x2, 0xf, 0xb, 0x8, 0x3, 0xa, 0x6, 0xc, 0x5, 0x9, 0x0, 0x7}
+	invSbox = [16]byte{0xe, 0x3, 0x4, 0x8, 0x1, 0xc, 0xa, 0xf, 0x7, 0xd, 0x9, 0x6, 0xb, 0x2, 0x0, 0x5}
+)
+
+// mixColumn is Mini-AES' MixColumn matrix. It's its own inverse.
+var mixColumn = [2][2]byte{{0x3, 0x2}, {0x2, 0x3}}
+
+// mul multiplies two elements of GF(2^4), modulo x^4 + x + 1.
+func mul(a, b byte) (out byte) {
+	for i := 0; i < 4; i++ {
+		if b&1 == 1 {
+			out ^= a
+		}
+
+		a, b = a<<1, b>>1
+		if a&0x10 != 0 {
+			a ^= 0x13
+		}
+	}
+
+	return
+}
+
+// toNibbles splits the first block of block into its four nibbles, in column-major order.
+func toNibbles(block []byte) [4]byte {
+	return [4]byte{block[0] >> 4, block[0] & 0xf, block[1] >> 4, block[1] & 0xf}
+}
+
+// fromNibbles writes the four nibbles of state into the first block of block.
+func fromNibbles(block []byte, state [4]byte) {
+	block[0], block[1] = state[0]<<4|state[1], state[2]<<4|state[3]
+}
+
+// ShiftRow is the nibble permutation in Mini-AES. It swaps the second nibble of each column, and is its own inverse.
+func ShiftRow(i int) int {
+	return []int{0, 3, 2, 1}[i]
+}
+
+type Reference struct {
+	// A 2-byte Mini-AES key.
+	Key []byte
+}
+
+// BlockSize returns the block size of Mini-AES. (Necessary to implement cipher.Block.)
+func (ref Reference) BlockSize() int { return 2 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (ref Reference) Encrypt(dst, src []byte) {
+	roundKeys := ref.StretchedKey()
+	state := toNibbles(src)
+
+	state = addRoundKey(roundKeys[0], state)
+
+	state = shiftRow(subNibbles(sbox, state))
+	state = addRoundKey(roundKeys[1], mixColumns(state))
+
+	state = shiftRow(subNibbles(sbox, state))
+	state = addRoundKey(roundKeys[2], state)
+
+	fromNibbles(dst, state)
+}
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (ref Reference) Decrypt(dst, src []byte) {
+	roundKeys := ref.StretchedKey()
+	state := toNibbles(src)
+
+	state = addRoundKey(roundKeys[2], state)
+	state = subNibbles(invSbox, shiftRow(state))
+
+	state = mixColumns(addRoundKey(roundKeys[1], state))
+	state = subNibbles(invSbox, shiftRow(state))
+
+	state = addRoundKey(roundKeys[0], state)
+
+	fromNibbles(dst, state)
+}
+
+// StretchedKey implements Mini-AES' key schedule. It returns the 3 round keys derived from the master key.
+func (ref Reference) StretchedKey() (out [3][4]byte) {
+	out[0] = toNibbles(ref.Key)
+
+	for round := 1; round < 3; round++ {
+		prev := out[round-1]
+
+		out[round][0] = prev[0] ^ sbox[prev[3]] ^ byte(round)
+		out[round][1] = prev[1] ^ out[round][0]
+		out[round][2] = prev[2] ^ out[round][1]
+		out[round][3] = prev[3] ^ out[round][2]
+	}
+
+	return
+}
+
+func addRoundKey(roundKey, state [4]byte) (out [4]byte) {
+	for pos := 0; pos < 4; pos++ {
+		out[pos] = state[pos] ^ roundKey[pos]
+	}
+
+	return
+}
+
+func subNibbles(box [16]byte, state [4]byte) (out [4]byte) {
+	for pos := 0; pos < 4; pos++ {
+		out[pos] = box[state[pos]]
+	}
+
+	return
+}
+
+func shiftRow(state [4]byte) (out [4]byte) {
+	for pos := 0; pos < 4; pos++ {
+		out[pos] = state[ShiftRow(pos)]
+	}
+
+	return
+}
+
+func mixColumns(state [4]byte) (out [4]byte) {
+	for col := 0; col < 4; col += 2 {
+		for row := 0; row < 2; row++ {
+			out[col+row] = mul(mixColumn[row][0], state[col]) ^ mul(mixColumn[row][1], state[col+1])
+		}
+	}
+
+	return
+}
@@ -0,0 +1,78 @@
+package toyaes
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	key  = []byte{0xc3, 0xf0}
+	seed = []byte{38, 41, 142, 156, 29, 181, 23, 194, 21, 250, 223, 183, 210, 168, 214, 145}
+)
+
+func TestReference(t *testing.T) {
+	// Test vector from the Mini-AES paper.
+	in, out := []byte{0x9c, 0x63}, []byte{0x72, 0xc6}
+
+	cand := make([]byte, 2)
+	Reference{key}.Encrypt(cand, in)
+
+	if !bytes.Equal(out, cand) {
+		t.Fatalf("Real disagrees with result! %x != %x", out, cand)
+	}
+
+	Reference{key}.Decrypt(cand, cand)
+
+	if !bytes.Equal(in, cand) {
+		t.Fatalf("Decryption disagrees with plaintext! %x != %x", in, cand)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	constr := GenerateKeys(key, seed)
+	real, cand := make([]byte, 2), make([]byte, 2)
+
+	for x := 0; x < 1<<16; x++ {
+		in := []byte{byte(x >> 8), byte(x)}
+
+		Reference{key}.Encrypt(real, in)
+		constr.Encrypt(cand, in)
+
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Real disagrees with result on %x! %x != %x", in, real, cand)
+		}
+
+		// Round-trip through the reference decryption.
+		Reference{key}.Decrypt(cand, cand)
+
+		if !bytes.Equal(in, cand) {
+			t.Fatalf("Decryption disagrees with plaintext! %x != %x", in, cand)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	constr := GenerateKeys(key, seed)
+
+	serialized := constr.Serialize()
+	parsed, err := Parse(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	real, cand := make([]byte, 2), make([]byte, 2)
+	for x := 0; x < 1<<16; x++ {
+		in := []byte{byte(x >> 8), byte(x)}
+
+		constr.Encrypt(real, in)
+		parsed.Encrypt(cand, in)
+
+		if !bytes.Equal(real, cand) {
+			t.Fatalf("Parsed construction disagrees with original on %x! %x != %x", in, real, cand)
+		}
+	}
+
+	if _, err := Parse(serialized[:len(serialized)-1]); err == nil {
+		t.Fatalf("Truncated serialization was parsed!")
+	}
+}
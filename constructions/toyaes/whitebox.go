@@ -0,0 +1,111 @@
+package toyaes
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/random"
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
+)
+
+// Construction is a Chow-style white-box of Mini-AES encryption. The first round is a T-Box/MixColumn table for each
+// nibble of the state, whose outputs are XORed together by XOR tables, and the second round is a T-Box for each nibble
+// holding the last two round keys. Values between tables are hidden by random nibble encodings.
+type Construction struct {
+	TBoxMixColumn [4]table.Byte   // [position]
+	XORTable      [4]table.Nibble // [position]
+	TBox          [4]table.Nibble // [position]
+}
+
+// BlockSize returns the block size of Mini-AES.
+func (constr Construction) BlockSize() int { return 2 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr Construction) Encrypt(dst, src []byte) {
+	state := shiftRow(toNibbles(src))
+
+	// Apply the T-Box/MixColumn tables to each column and XOR their contributions to each row together.
+	mid := [4]byte{}
+	for col := 0; col < 4; col += 2 {
+		top, bottom := constr.TBoxMixColumn[col].Get(state[col]), constr.TBoxMixColumn[col+1].Get(state[col+1])
+
+		mid[col+0] = constr.XORTable[col+0].Get(top&0xf0 | bottom>>4)
+		mid[col+1] = constr.XORTable[col+1].Get(top<<4 | bottom&0x0f)
+	}
+
+	state = shiftRow(mid)
+	for pos := 0; pos < 4; pos++ {
+		state[pos] = constr.TBox[pos].Get(state[pos])
+	}
+
+	fromNibbles(dst, state)
+}
+
+// tboxMixColumn computes AddRoundKey and NibbleSub on a nibble in the first round, and then its contribution to each row
+// of its column after MixColumn. Row is the row of the state matrix the nibble is in.
+type tboxMixColumn struct {
+	KeyNibble byte
+	Row       int
+}
+
+func (tmc tboxMixColumn) Get(i byte) byte {
+	v := sbox[i^tmc.KeyNibble]
+	return mul(mixColumn[0][tmc.Row], v)<<4 | mul(mixColumn[1][tmc.Row], v)
+}
+
+// tbox computes AddRoundKey, NibbleSub, and AddRoundKey on a nibble in the second round.
+type tbox struct {
+	KeyNibble1, KeyNibble2 byte
+}
+
+func (tb tbox) Get(i byte) byte {
+	return sbox[i^tb.KeyNibble1] ^ tb.KeyNibble2
+}
+
+// stepEncoding returns the encoding on the contribution of the nibble in srcRow of the column starting at position col
+// to dstRow, between a T-Box/MixColumn table and an XOR table.
+func stepEncoding(rs *random.Source, col, srcRow, dstRow int) encoding.Nibble {
+	label := make([]byte, 16)
+	label[0], label[1], label[2], label[3] = 'S', byte(col), byte(srcRow), byte(dstRow)
+
+	return rs.Shuffle(label)
+}
+
+// roundEncoding returns the encoding on the output of the XOR table in the given position, between the two rounds.
+func roundEncoding(rs *random.Source, pos int) encoding.Nibble {
+	label := make([]byte, 16)
+	label[0], label[1] = 'R', byte(pos)
+
+	return rs.Shuffle(label)
+}
+
+// GenerateKeys creates a white-boxed version of Mini-AES with given key for encryption, with any non-determinism
+// generated by seed.
+func GenerateKeys(key, seed []byte) (out Construction) {
+	rs := random.NewSource("Toy AES", seed)
+	roundKeys := Reference{key}.StretchedKey()
+
+	for pos := 0; pos < 4; pos++ {
+		col, row := pos/2*2, pos%2
+
+		out.TBoxMixColumn[pos] = encoding.ByteTable{
+			encoding.IdentityByte{},
+			encoding.ConcatenatedByte{stepEncoding(&rs, col, row, 0), stepEncoding(&rs, col, row, 1)},
+			tboxMixColumn{roundKeys[0][ShiftRow(pos)], row},
+		}
+
+		out.XORTable[pos] = encoding.NibbleTable{
+			encoding.ConcatenatedByte{stepEncoding(&rs, col, 0, row), stepEncoding(&rs, col, 1, row)},
+			roundEncoding(&rs, pos),
+			common.NibbleXORTable{},
+		}
+
+		out.TBox[pos] = encoding.NibbleTable{
+			roundEncoding(&rs, ShiftRow(pos)),
+			encoding.IdentityByte{},
+			tbox{roundKeys[1][ShiftRow(pos)], roundKeys[2][pos]},
+		}
+	}
+
+	return
+}
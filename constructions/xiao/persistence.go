@@ -5,6 +5,8 @@ import (
 
 	"github.com/OpenWhiteBox/primitives/matrix"
 	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/common"
 )
 
 const (
@@ -14,9 +16,10 @@ const (
 	tmcSize    = 65536 * 4
 )
 
-// Serialize serializes a white-box construction into a byte slice.
+// Serialize serializes a white-box construction into a byte slice, after common.XiaoMagic.
 func (constr *Construction) Serialize() []byte {
-	out, base := make([]byte, fullSize), 0
+	out := make([]byte, common.MagicSize+fullSize)
+	base := copy(out, common.XiaoMagic)
 
 	base += serializeMatrix(out[base:], constr.FinalMask)
 
@@ -33,10 +36,16 @@ func (constr *Construction) Serialize() []byte {
 	return out
 }
 
-// Parse parses a byte array into a white-box construction. It returns an error if the byte array isn't long enough.
+// Parse parses a byte array into a white-box construction. It returns an error if the byte array doesn't start with
+// common.XiaoMagic or isn't long enough. A serialization from before the header, which is exactly common.LegacyXiaoSize
+// bytes long, is parsed too.
 func Parse(in []byte) (constr Construction, err error) {
 	var rest []byte
 
+	if in, err = common.StripLegacyMagic(in, common.XiaoMagic, common.LegacyXiaoSize); err != nil {
+		return
+	}
+
 	constr.FinalMask, rest = parseMatrix(in)
 
 	for i, _ := range constr.ShiftRows {
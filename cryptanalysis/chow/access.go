@@ -0,0 +1,28 @@
+package chow
+
+import (
+	"bytes"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// recorded returns a copy of constr that appends the index of every table lookup it makes to log. A construction's
+// tables are always looked up in the same order, so the indices of the lookups, in order, are its whole memory access
+// pattern.
+func recorded(constr *chow.Construction, log *[]byte) chow.Construction {
+	return constr.WithLookupHook(func(_ chow.LookupKind, index byte) { *log = append(*log, index) })
+}
+
+// AccessPatternVaries records the index of every table lookup the construction makes while encrypting two different
+// blocks, and returns true if the two patterns differ. An access pattern that depends on the input is what cache-timing
+// and other side-channel attacks on table implementations need; a straightforward table implementation always varies.
+func AccessPatternVaries(c *chow.Construction) bool {
+	var a, b []byte
+	constrA, constrB := recorded(c, &a), recorded(c, &b)
+
+	dst := make([]byte, 16)
+	constrA.Encrypt(dst, bytes.Repeat([]byte{0x00}, 16))
+	constrB.Encrypt(dst, bytes.Repeat([]byte{0xff}, 16))
+
+	return !bytes.Equal(a, b)
+}
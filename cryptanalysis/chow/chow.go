@@ -147,3 +147,20 @@ func RecoverKey(constr *chow.Construction) []byte {
 
 	return backOneRound(backOneRound(key[:], 2), 1)
 }
+
+// RecoverRoundKeys returns the AES round keys used to generate the given white-box construction, for validating the key
+// schedule in controlled tests. It expands the key that RecoverKey finds, so like RecoverKey it works whatever the
+// construction's external encodings are.
+//
+// It's here rather than a method on chow.Construction because it's built on the attack in this package, which imports
+// constructions/chow.
+func RecoverRoundKeys(constr *chow.Construction) [][16]byte {
+	stretched := (&saes.Construction{RecoverKey(constr)}).StretchedKey()
+
+	out := make([][16]byte, len(stretched))
+	for i, roundKey := range stretched {
+		copy(out[i][:], roundKey)
+	}
+
+	return out
+}
@@ -5,9 +5,14 @@ import (
 
 	"bytes"
 	"crypto/rand"
+	"strings"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/table"
 
 	"github.com/OpenWhiteBox/AES/constructions/chow"
 	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
 )
 
 func TestRecoverKey(t *testing.T) {
@@ -25,6 +30,199 @@ func TestRecoverKey(t *testing.T) {
 	}
 }
 
+func TestRecoverRoundKeys(t *testing.T) {
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	unmasked, _, _ := chow.GenerateEncryptionKeys(key, key, common.SameMasks(common.IdentityMask))
+	masked, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	real := (&saes.Construction{key}).StretchedKey()
+	for _, constr := range []*chow.Construction{&unmasked, &masked} {
+		cand := RecoverRoundKeys(constr)
+
+		for i := range real {
+			if !bytes.Equal(real[i], cand[i][:]) {
+				t.Fatalf("Recovered wrong round key %v!\nreal=%x\ncand=%x", i, real[i], cand[i])
+			}
+		}
+	}
+}
+
+func TestQuickAttackResistance(t *testing.T) {
+	key := make([]byte, 16)
+
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	if err := QuickAttackResistance(&constr); err != nil {
+		t.Fatalf("Correctly generated construction failed: %v", err)
+	}
+
+	unmasked, _, _ := chow.GenerateEncryptionKeys(key, key, common.SameMasks(common.IdentityMask))
+	if err := QuickAttackResistance(&unmasked); err == nil {
+		t.Fatalf("Construction without external masks passed!")
+	}
+
+	// Strip the encodings off of one XOR table.
+	weak := constr
+	weak.HighXORTable[4][7][1] = common.NibbleXORTable{}
+	if err := QuickAttackResistance(&weak); err == nil {
+		t.Fatalf("Construction with an unencoded XOR table passed!")
+	}
+
+	// Strip the non-linear encodings off of round 1's first input byte and first output byte, leaving only the mixing
+	// bijections.
+	affine := constr
+	tboxtyi := affine.TBoxTyiTable[1][0].(encoding.WordTable)
+	tboxtyi.In = tboxtyi.In.(encoding.ComposedBytes)[0]
+	affine.TBoxTyiTable[1][0] = tboxtyi
+
+	for pos := 0; pos < 2; pos++ {
+		xor := affine.LowXORTable[1][pos][2].(encoding.NibbleTable)
+		xor.Out = encoding.IdentityByte{}
+		affine.LowXORTable[1][pos][2] = xor
+	}
+
+	if err := QuickAttackResistance(&affine); err == nil {
+		t.Fatalf("Construction with affine round encodings passed!")
+	}
+}
+
+func TestEncodingEntropy(t *testing.T) {
+	key := make([]byte, 16)
+
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	// Replace one XOR table's output encoding with the identity.
+	weak := constr.HighXORTable[2][3][1].(encoding.NibbleTable)
+	weak.Out = encoding.IdentityByte{}
+	constr.HighXORTable[2][3][1] = weak
+
+	entropy := EncodingEntropy(&constr)
+	if entropy["HighXORTable[2][3][1]"] != 0 {
+		t.Fatalf("Identity encoding has non-zero entropy! %v", entropy["HighXORTable[2][3][1]"])
+	}
+
+	total := 0.0
+	for name, e := range entropy {
+		if strings.HasPrefix(name, "HighXORTable") || strings.HasPrefix(name, "LowXORTable") {
+			total += e
+		}
+	}
+
+	if avg := total / (2*9*32*3 - 1); avg < 14 {
+		t.Fatalf("Random encodings have low entropy! %v", avg)
+	}
+}
+
+func TestDiffusionScore(t *testing.T) {
+	key := make([]byte, 16)
+
+	masked, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	unmasked, _, _ := chow.GenerateEncryptionKeys(key, key, common.SameMasks(common.IdentityMask))
+
+	maskedScore, unmaskedScore := DiffusionScore(&masked), DiffusionScore(&unmasked)
+
+	if maskedScore < 0.45 {
+		t.Fatalf("Masked construction diffuses poorly! %v", maskedScore)
+	} else if unmaskedScore >= maskedScore {
+		t.Fatalf("Unmasked construction diffuses as well as masked construction! %v >= %v", unmaskedScore, maskedScore)
+	}
+}
+
+// dropLowBit clears the low bit of its input. It implements table.Byte.
+type dropLowBit struct{}
+
+func (dropLowBit) Get(i byte) byte { return i &^ 1 }
+
+func TestCollisionProfile(t *testing.T) {
+	key := make([]byte, 16)
+
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	for id, count := range CollisionProfile(&constr) {
+		if count != 0 {
+			t.Fatalf("Correctly generated construction has %v collisions in table %v!", count, id)
+		}
+	}
+
+	// Make one T-Box/Tyi Table ignore the low bit of its input, so every output is hit twice.
+	constr.TBoxTyiTable[3][5] = table.ComposedToWord{dropLowBit{}, constr.TBoxTyiTable[3][5]}
+	if count := CollisionProfile(&constr)[16*3+5]; count != 128 {
+		t.Fatalf("Table that ignores a bit has %v collisions, not 128!", count)
+	}
+}
+
+func TestHammingProfile(t *testing.T) {
+	key := make([]byte, 16)
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	encoded := HammingProfile(&constr, 3, 5)
+
+	total := 0
+	for _, count := range encoded {
+		total += count
+	}
+	if total != 256 {
+		t.Fatalf("Profile counts %v outputs, not 256!", total)
+	}
+
+	// Swap in a T-Box/Tyi Table without any encodings.
+	constr.TBoxTyiTable[3][5] = chow.TBoxTyiTable{common.TBox{Constr: saes.Construction{key}}, common.TyiTable(1)}
+
+	if plain := HammingProfile(&constr, 3, 5); plain == encoded {
+		t.Fatalf("Encoded and plain tables have the same profile!")
+	}
+}
+
+func TestSimulateDCA(t *testing.T) {
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	// Without external encodings, the nibble encodings are all that's in the way.
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.SameMasks(common.IdentityMask))
+
+	recovered, err := SimulateDCA(&constr, 1024)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(recovered) < 4 {
+		t.Fatalf("Only recovered %v key bytes!", len(recovered))
+	}
+
+	for pos, cand := range recovered {
+		if cand != key[pos] {
+			t.Fatalf("Recovered wrong key byte %v! %x != %x", pos, key[pos], cand)
+		}
+	}
+}
+
+func TestEstimateAttackComplexity(t *testing.T) {
+	key := make([]byte, 16)
+
+	unmasked, _, _ := chow.GenerateEncryptionKeys(key, key, common.SameMasks(common.IdentityMask))
+	masked, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+
+	full := EstimateAttackComplexity(&masked)
+	if full < 1<<22 {
+		t.Fatalf("Correctly generated construction is estimated to fall in %v operations!", full)
+	} else if low := EstimateAttackComplexity(&unmasked); low >= full {
+		t.Fatalf("Unmasked construction is estimated as at least as hard as masked construction! %v >= %v", low, full)
+	}
+
+	// Strip the encodings off of one XOR table.
+	masked.HighXORTable[4][7][1] = common.NibbleXORTable{}
+	if weak := EstimateAttackComplexity(&masked); weak >= full {
+		t.Fatalf("Construction with an unencoded XOR table is estimated as at least as hard! %v >= %v", weak, full)
+	}
+}
+
+func TestAccessPatternVaries(t *testing.T) {
+	key := make([]byte, 16)
+
+	constr, _, _ := chow.GenerateEncryptionKeys(key, key, common.IndependentMasks{common.RandomMask, common.RandomMask})
+	if !AccessPatternVaries(&constr) {
+		t.Fatalf("Table lookups don't depend on the input!")
+	}
+}
+
 // func TestMakeConstants(t *testing.T) {
 //   MC := gfmatrix.Matrix{
 //     gfmatrix.Row{2, 3, 1, 1},
@@ -0,0 +1,42 @@
+package chow
+
+import (
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// collisions returns how many inputs of t map to an output that a smaller input already maps to.
+func collisions(t table.Word) (out int) {
+	seen := make(map[[4]byte]bool, 256)
+
+	for x := 0; x < 256; x++ {
+		y := t.Get(byte(x))
+		if seen[y] {
+			out++
+		}
+		seen[y] = true
+	}
+
+	return
+}
+
+// CollisionProfile counts the output collisions of each of the construction's round tables. T-Box/Tyi Table [round][pos]
+// is at key 16*round+pos, and MB^(-1) Table [round][pos] is at key 144+16*round+pos.
+//
+// Every round table of a well-formed construction is injective--the T-Boxes are permutations, the Tyi Tables multiply
+// by non-zero constants, and the MB^(-1) Tables are slices of invertible matrices--and encodings are bijections, so
+// they can't add collisions either. Any collision means a table has lost part of its input, which is a bug in
+// generation that also gives collision-based attacks something to work with.
+func CollisionProfile(constr *chow.Construction) map[int]int {
+	out := make(map[int]int)
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 16; pos++ {
+			out[16*round+pos] = collisions(constr.TBoxTyiTable[round][pos])
+			out[144+16*round+pos] = collisions(constr.MBInverseTable[round][pos])
+		}
+	}
+
+	return out
+}
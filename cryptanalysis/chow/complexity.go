@@ -0,0 +1,61 @@
+package chow
+
+import (
+	"math"
+
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+const (
+	// lookupWork is roughly the work to read the key off of a construction whose external masks don't spread their
+	// bytes: 256 lookups into each of the 16 first-round T-Boxes.
+	lookupWork = 12
+
+	// bgeWork is roughly the work of the BGE attack against a construction with random nibble encodings, as log2 of
+	// the number of operations. It's the improved estimate from Tolhuizen's and Lepoint et al.'s work on the attack.
+	bgeWork = 22
+)
+
+// EstimateAttackComplexity returns a rough estimate of the number of operations it takes to recover the key of the
+// construction, for security reporting. It's not a proof of anything, just where the construction lands between the
+// known attacks:
+//
+// If an external mask doesn't spread each byte of its input across the block, the first round can be isolated and the
+// key read off of it for about 2^12 operations. Otherwise, the BGE attack is the best known, at about 2^22 operations
+// when every XOR table's output is under a nibble encoding. Each unencoded XOR table leaks part of the state to the
+// attack for free, so the estimate falls towards 2^12 with the fraction of XOR tables that compute a plain XOR.
+func EstimateAttackComplexity(c *chow.Construction) (operations float64) {
+	for pos := 0; pos < 16; pos++ {
+		if spread(c.InputMask[pos]) <= 2 || spread(c.TBoxOutputMask[pos]) <= 2 {
+			return math.Exp2(lookupWork)
+		}
+	}
+
+	encoded, total := 0, 0
+	count := func(t table.Nibble) {
+		if !isPlainXOR(t) {
+			encoded++
+		}
+		total++
+	}
+
+	for pos := 0; pos < 32; pos++ {
+		for gate := 0; gate < 15; gate++ {
+			count(c.InputXORTables[pos][gate])
+			count(c.OutputXORTables[pos][gate])
+		}
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				count(c.HighXORTable[round][pos][gate])
+				count(c.LowXORTable[round][pos][gate])
+			}
+		}
+	}
+
+	return math.Exp2(lookupWork + (bgeWork-lookupWork)*float64(encoded)/float64(total))
+}
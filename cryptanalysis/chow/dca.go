@@ -0,0 +1,111 @@
+package chow
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+	"github.com/OpenWhiteBox/AES/constructions/common"
+	"github.com/OpenWhiteBox/AES/constructions/saes"
+)
+
+// dcaMargin is how much higher the best key guess's peak has to be than the runner-up's for SimulateDCA to count it as
+// recovered.
+const dcaMargin = 1.25
+
+// dcaTrace returns the simulated leakage of encrypting in: the outputs of the first round's T-Box/Tyi Tables, which are
+// the first values in an encryption that depend on the key.
+func dcaTrace(constr *chow.Construction, in [16]byte) (out [16][4]byte) {
+	blocks, state := [16][16]byte{}, make([]byte, 16)
+	for pos := 0; pos < 16; pos++ {
+		blocks[pos] = constr.InputMask[pos].Get(in[pos])
+	}
+	constr.InputXORTables.SquashBlocks(blocks, state)
+
+	for pos := 0; pos < 16; pos++ {
+		out[pos] = constr.TBoxTyiTable[0][pos].Get(state[common.UnShiftRows(pos)])
+	}
+
+	return
+}
+
+// SimulateDCA runs differential computation analysis on the construction with the given number of traces of random
+// plaintexts, and returns the key bytes it recovers by their position in the key.
+//
+// Each trace is every bit of the outputs of the first round's T-Box/Tyi Tables. For each key byte and guess, traces are
+// split by a bit of the S-Box's output under that guess, and the guess is scored by the biggest difference of means of
+// any trace bit between the two halves. Nibble encodings don't hide the correlation between their inputs and outputs
+// well, so the right guess usually stands out. A byte is only returned if its best guess beats the runner-up by
+// dcaMargin. External encodings on the input decorrelate the traces from the plaintext, so nothing is recovered then.
+func SimulateDCA(constr *chow.Construction, traces int) (map[int]byte, error) {
+	if traces < 1 {
+		return nil, errors.New("Need at least one trace!")
+	}
+
+	ins, leaks := make([][16]byte, traces), make([][16][4]byte, traces)
+	for i := range ins {
+		if _, err := rand.Read(ins[i][:]); err != nil {
+			return nil, err
+		}
+		leaks[i] = dcaTrace(constr, ins[i])
+	}
+
+	sbox, aes := [256]byte{}, saes.Construction{}
+	for x := 0; x < 256; x++ {
+		sbox[x] = aes.SubByte(byte(x))
+	}
+
+	out := make(map[int]byte)
+
+	for pos := 0; pos < 16; pos++ {
+		src := common.UnShiftRows(pos)
+
+		// Tally, for each value of the plaintext byte, how many traces have it and how often each trace bit is set.
+		counts, ones := [256]int{}, [32][256]int{}
+		for i := range ins {
+			counts[ins[i][src]]++
+			for bit := uint(0); bit < 32; bit++ {
+				ones[bit][ins[i][src]] += int(leaks[i][pos][bit/8]>>(bit%8)) & 1
+			}
+		}
+
+		scores := [256]float64{}
+		for guess := 0; guess < 256; guess++ {
+			for target := uint(0); target < 8; target++ {
+				for bit := 0; bit < 32; bit++ {
+					var set, n [2]int
+					for x := 0; x < 256; x++ {
+						half := (sbox[x^guess] >> target) & 1
+						set[half] += ones[bit][x]
+						n[half] += counts[x]
+					}
+
+					if n[0] == 0 || n[1] == 0 {
+						continue
+					}
+					scores[guess] = math.Max(scores[guess],
+						math.Abs(float64(set[0])/float64(n[0])-float64(set[1])/float64(n[1])))
+				}
+			}
+		}
+
+		best, second := 0, 1
+		if scores[second] > scores[best] {
+			best, second = second, best
+		}
+		for guess := 2; guess < 256; guess++ {
+			if scores[guess] > scores[best] {
+				best, second = guess, best
+			} else if scores[guess] > scores[second] {
+				second = guess
+			}
+		}
+
+		if scores[best] >= dcaMargin*scores[second] {
+			out[src] = byte(best)
+		}
+	}
+
+	return out, nil
+}
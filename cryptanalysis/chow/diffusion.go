@@ -0,0 +1,64 @@
+package chow
+
+import (
+	"crypto/sha256"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// diffusionSamples is the number of inputs DiffusionScore flips bits of.
+const diffusionSamples = 8
+
+// trace returns the encoded state of the white-box after the input mask and after each of its first nine rounds, on the
+// input block in.
+func trace(constr *chow.Construction, in [16]byte) (states [10][16]byte) {
+	stretched := [16][16]byte{}
+	for pos := 0; pos < 16; pos++ {
+		stretched[pos] = constr.InputMask[pos].Get(in[pos])
+	}
+	constr.InputXORTables.SquashBlocks(stretched, states[0][:])
+
+	for r := 0; r < 9; r++ {
+		shifted, shift := [16]byte{}, chow.ShiftRowsMap(r)
+		for pos := 0; pos < 16; pos++ {
+			shifted[pos] = states[r][shift[pos]]
+		}
+
+		round{construction: constr, round: r}.Encrypt(states[r+1][:], shifted[:])
+	}
+
+	return
+}
+
+// DiffusionScore measures how well the encodings of a white-box spread changes to its input across its intermediate
+// states. For a fixed set of inputs, it flips each bit of the input and counts how many bits of each encoded state
+// change. The score is the average fraction of changed bits, so it's between 0 and 1, and 0.5 is ideal.
+func DiffusionScore(constr *chow.Construction) float64 {
+	changed, total := 0, 0
+
+	for sample := 0; sample < diffusionSamples; sample++ {
+		in := [16]byte{}
+		digest := sha256.Sum256([]byte{byte(sample)})
+		copy(in[:], digest[:])
+
+		real := trace(constr, in)
+
+		for bit := 0; bit < 128; bit++ {
+			flipped := in
+			flipped[bit/8] ^= 1 << uint(bit%8)
+
+			cand := trace(constr, flipped)
+
+			for i := range real {
+				for pos := 0; pos < 16; pos++ {
+					for diff := real[i][pos] ^ cand[i][pos]; diff != 0; diff &= diff - 1 {
+						changed++
+					}
+				}
+				total += 128
+			}
+		}
+	}
+
+	return float64(changed) / float64(total)
+}
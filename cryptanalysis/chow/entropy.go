@@ -0,0 +1,60 @@
+package chow
+
+import (
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// displaced returns how many of the 16 nibbles enc doesn't map to themselves.
+func displaced(enc encoding.Nibble) (out int) {
+	for x := byte(0); x < 16; x++ {
+		if enc.Encode(x) != x {
+			out++
+		}
+	}
+
+	return
+}
+
+// EncodingEntropy measures how much each internal nibble encoding of a construction scrambles, for auditing. Every
+// nibble-wise value in the construction is the output of an XOR table, so it returns, for each XOR table by name (like
+// "HighXORTable[3][5][1]"), how many of the 16 nibbles its output encoding displaces. The identity scores 0 and a random
+// encoding usually scores close to 16.
+//
+// Encodings can only be read off of a generated construction. Tables that were parsed from a serialized construction
+// are skipped.
+func EncodingEntropy(constr *chow.Construction) map[string]float64 {
+	out := make(map[string]float64)
+
+	measure := func(t table.Nibble, name string, index ...int) {
+		if nt, ok := t.(encoding.NibbleTable); ok {
+			for _, i := range index {
+				name += fmt.Sprintf("[%v]", i)
+			}
+
+			out[name] = float64(displaced(nt.Out))
+		}
+	}
+
+	for pos := 0; pos < 32; pos++ {
+		for gate := 0; gate < 15; gate++ {
+			measure(constr.InputXORTables[pos][gate], "InputXORTables", pos, gate)
+			measure(constr.OutputXORTables[pos][gate], "OutputXORTables", pos, gate)
+		}
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				measure(constr.HighXORTable[round][pos][gate], "HighXORTable", round, pos, gate)
+				measure(constr.LowXORTable[round][pos][gate], "LowXORTable", round, pos, gate)
+			}
+		}
+	}
+
+	return out
+}
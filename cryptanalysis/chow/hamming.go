@@ -0,0 +1,25 @@
+package chow
+
+import (
+	"math/bits"
+
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// hammingProfile counts the outputs of t by Hamming weight.
+func hammingProfile(t table.Word) (out [33]int) {
+	for x := 0; x < 256; x++ {
+		y := t.Get(byte(x))
+		out[bits.OnesCount32(uint32(y[0])<<24|uint32(y[1])<<16|uint32(y[2])<<8|uint32(y[3]))]++
+	}
+
+	return
+}
+
+// HammingProfile counts the 256 outputs of the T-Box/Tyi Table at the given round and position by Hamming weight, which
+// models what a side-channel that leaks Hamming weights sees of it. The outputs are words, so weights go from 0 to 32.
+func HammingProfile(constr *chow.Construction, round, position int) [33]int {
+	return hammingProfile(constr.TBoxTyiTable[round][position])
+}
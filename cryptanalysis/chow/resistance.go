@@ -0,0 +1,132 @@
+package chow
+
+import (
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/table"
+
+	"github.com/OpenWhiteBox/AES/constructions/chow"
+)
+
+// isPlainXOR returns true if the XOR table computes the XOR of two nibbles without any encodings on it.
+func isPlainXOR(t table.Nibble) bool {
+	for x := 0; x < 256; x++ {
+		if t.Get(byte(x)) != byte(x>>4)^byte(x&0xf) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// spread returns how many nibbles of a mask table's output depend on its input.
+func spread(t table.Block) (out int) {
+	base := t.Get(0x00)
+	varies := [32]bool{}
+
+	for x := 1; x < 256; x++ {
+		cand := t.Get(byte(x))
+
+		for pos := 0; pos < 16; pos++ {
+			varies[2*pos+0] = varies[2*pos+0] || cand[pos]&0xf0 != base[pos]&0xf0
+			varies[2*pos+1] = varies[2*pos+1] || cand[pos]&0x0f != base[pos]&0x0f
+		}
+	}
+
+	for _, v := range varies {
+		if v {
+			out++
+		}
+	}
+
+	return
+}
+
+// differentialSpectrum returns how many times each count appears in the difference distribution table of f, over every
+// non-zero input difference. It's unchanged by composing f with affine maps on either side.
+func differentialSpectrum(f func(byte) byte) (out [257]int) {
+	for a := 1; a < 256; a++ {
+		row := [256]int{}
+		for x := 0; x < 256; x++ {
+			row[f(byte(x))^f(byte(x^a))]++
+		}
+
+		for _, count := range row {
+			out[count]++
+		}
+	}
+
+	return
+}
+
+// QuickAttackResistance runs cheap checks for weaknesses that would let the first steps of the BGE attack through for
+// free, which indicate a bug in generation. It's a guard, not an attack: it returns an error if an XOR table has no
+// encodings, or if an external mask keeps each byte of the input (or output) within one byte of the state, so that the
+// first and last rounds can be isolated without any work.
+//
+// It also runs the step of the attack that the rest builds on, in its cheapest form. It isolates round 1 and, for each
+// input byte, takes the first output byte of its column as a function of it. Under the internal encodings, that's the
+// S-box with an affine map on each side and a non-linear nibble encoding around the whole thing, which BGE spends most
+// of its work stripping. If the function's differential spectrum matches the S-box's, there's nothing to strip: the
+// round byte is already affine-equivalent to the S-box, and the key byte falls out of the equivalence.
+func QuickAttackResistance(constr *chow.Construction) error {
+	for pos := 0; pos < 16; pos++ {
+		if spread(constr.InputMask[pos]) <= 2 {
+			return fmt.Errorf("InputMask[%v] doesn't spread its input byte, so the input mask is trivial!", pos)
+		} else if spread(constr.TBoxOutputMask[pos]) <= 2 {
+			return fmt.Errorf("TBoxOutputMask[%v] doesn't spread its input byte, so the output mask is trivial!", pos)
+		}
+	}
+
+	check := func(name string, t table.Nibble, index ...int) error {
+		if isPlainXOR(t) {
+			return fmt.Errorf("%v%v is an unencoded XOR!", name, index)
+		}
+
+		return nil
+	}
+
+	for pos := 0; pos < 32; pos++ {
+		for gate := 0; gate < 15; gate++ {
+			if err := check("InputXORTables", constr.InputXORTables[pos][gate], pos, gate); err != nil {
+				return err
+			} else if err := check("OutputXORTables", constr.OutputXORTables[pos][gate], pos, gate); err != nil {
+				return err
+			}
+		}
+	}
+
+	for round := 0; round < 9; round++ {
+		for pos := 0; pos < 32; pos++ {
+			for gate := 0; gate < 3; gate++ {
+				if err := check("HighXORTable", constr.HighXORTable[round][pos][gate], round, pos, gate); err != nil {
+					return err
+				} else if err := check("LowXORTable", constr.LowXORTable[round][pos][gate], round, pos, gate); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	isolated := round{construction: constr, round: 1}
+	target := differentialSpectrum(sbox{}.Encode)
+
+	for pos := 0; pos < 16; pos++ {
+		first := pos / 4 * 4
+
+		f := [256]byte{}
+		for x := 0; x < 256; x++ {
+			in, out := make([]byte, 16), make([]byte, 16)
+			in[pos] = byte(x)
+			isolated.Encrypt(out, in)
+
+			f[x] = out[first]
+		}
+
+		if differentialSpectrum(func(x byte) byte { return f[x] }) == target {
+			return fmt.Errorf("Round 1's output byte %v is affine-equivalent to the S-box in input byte %v!", first, pos)
+		}
+	}
+
+	return nil
+}